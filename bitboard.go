@@ -0,0 +1,85 @@
+package main
+
+import "slices"
+
+// bitset is a fixed-size set of small integers. A board of up to 8x8 cells
+// fits in a single word; larger boards use one word per 64 cells.
+type bitset []uint64
+
+func newBitset(size int) bitset {
+	return make(bitset, (size+63)/64)
+}
+
+func (b bitset) set(i int) {
+	b[i/64] |= 1 << (i % 64)
+}
+
+func (b bitset) clear(i int) {
+	b[i/64] &^= 1 << (i % 64)
+}
+
+func (b bitset) has(i int) bool {
+	return b[i/64]&(1<<(i%64)) != 0
+}
+
+func (b bitset) reset() {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// lineSet tracks the queens on one family of lines (rows, columns or one of
+// the diagonal directions). A board has at most 2*MaxBoardSize-1 lines in a
+// family, so both masks fit in a uint64. The counts are only needed because
+// hard mode lets several queens share a line.
+type lineSet struct {
+	count    []uint8
+	occupied uint64
+	crowded  uint64
+}
+
+func newLineSet(lines int) lineSet {
+	return lineSet{count: make([]uint8, lines)}
+}
+
+// clone returns a copy of the lines that doesn't share their counts.
+func (l lineSet) clone() lineSet {
+	l.count = slices.Clone(l.count)
+	return l
+}
+
+func (l *lineSet) add(i int) {
+	l.count[i]++
+	l.occupied |= 1 << i
+	if l.count[i] > 1 {
+		l.crowded |= 1 << i
+	}
+}
+
+func (l *lineSet) remove(i int) {
+	l.count[i]--
+	if l.count[i] == 0 {
+		l.occupied &^= 1 << i
+	}
+	if l.count[i] < 2 {
+		l.crowded &^= 1 << i
+	}
+}
+
+func (l *lineSet) reset() {
+	for i := range l.count {
+		l.count[i] = 0
+	}
+	l.occupied = 0
+	l.crowded = 0
+}
+
+// has reports whether any queen is on line i.
+func (l *lineSet) has(i int) bool {
+	return l.occupied&(1<<i) != 0
+}
+
+// shared reports whether two or more queens are on line i.
+func (l *lineSet) shared(i int) bool {
+	return l.crowded&(1<<i) != 0
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrEmptyBoard           = errors.New("empty board")
+	ErrBoardSize            = errors.New("unsupported board size")
+	ErrRowLength            = errors.New("wrong row length")
+	ErrBadCell              = errors.New("unexpected character")
+	ErrNoQueenInRow         = errors.New("no queen in row")
+	ErrTwoQueensInRow       = errors.New("two queens in one row")
+	ErrNotASolution         = errors.New("queens attack each other")
+	ErrDuplicateFundamental = errors.New("duplicate fundamental solution")
+)
+
+// BoardError reports a problem with a board file, at a 1-based line number,
+// or for the file as a whole when Line is 0.
+type BoardError struct {
+	File   string
+	Line   int
+	Err    error
+	Detail string
+}
+
+func (e *BoardError) Error() string {
+	msg := e.Err.Error()
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, msg)
+}
+
+func (e *BoardError) Unwrap() error {
+	return e.Err
+}
+
+// ParseSolution reads a complete solution drawn one row per line with '.'
+// for an empty cell and 'Q' for a queen. Unlike ParseBoard it rejects
+// anything else: rows of the wrong length, rows without exactly one queen,
+// and queens that attack each other. The returned error is a *BoardError
+// with File left empty.
+func ParseSolution(content string) ([]Position, error) {
+	content = strings.TrimRight(content, "\r\n")
+	if strings.TrimSpace(content) == "" {
+		return nil, &BoardError{Err: ErrEmptyBoard}
+	}
+
+	lines := strings.Split(content, "\n")
+	size := len(lines)
+	if size < MinBoardSize || size > MaxBoardSize {
+		return nil, &BoardError{Err: ErrBoardSize, Detail: fmt.Sprintf("%d rows, expected %d to %d", size, MinBoardSize, MaxBoardSize)}
+	}
+
+	queens := NewQueens(size)
+	for row, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		lineNum := row + 1
+
+		if len(line) != size {
+			return nil, &BoardError{Line: lineNum, Err: ErrRowLength, Detail: fmt.Sprintf("%d cells, expected %d", len(line), size)}
+		}
+
+		col := -1
+		for i, char := range line {
+			switch char {
+			case '.':
+			case 'Q':
+				if col != -1 {
+					return nil, &BoardError{Line: lineNum, Err: ErrTwoQueensInRow}
+				}
+				col = i
+			default:
+				return nil, &BoardError{Line: lineNum, Err: ErrBadCell, Detail: fmt.Sprintf("%q", char)}
+			}
+		}
+
+		if col == -1 {
+			return nil, &BoardError{Line: lineNum, Err: ErrNoQueenInRow}
+		}
+
+		if err := queens.PlaceQueen(row, col); err != nil {
+			return nil, &BoardError{Line: lineNum, Err: ErrNotASolution, Detail: fmt.Sprintf("queen in column %d is attacked", col+1)}
+		}
+	}
+
+	return queens.queens, nil
+}
+
+type boardFile struct {
+	name      string
+	positions []Position
+}
+
+// readBoardFiles parses every *.txt file in fsys strictly, in file name
+// order. It returns the valid boards and an error for each invalid one,
+// including boards that repeat the fundamental solution of an earlier file.
+func readBoardFiles(fsys fs.FS) ([]boardFile, []error) {
+	files, err := fs.Glob(fsys, "*.txt")
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	sort.Strings(files)
+
+	var boards []boardFile
+	var errs []error
+	seen := make(map[string]string)
+	for _, file := range files {
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		positions, err := ParseSolution(string(content))
+		if err != nil {
+			var boardErr *BoardError
+			if errors.As(err, &boardErr) {
+				boardErr.File = file
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		key := CanonicalKey(positions, len(positions))
+		if first, ok := seen[key]; ok {
+			errs = append(errs, &BoardError{File: file, Err: ErrDuplicateFundamental, Detail: "same as " + first})
+			continue
+		}
+		seen[key] = file
+
+		boards = append(boards, boardFile{name: file, positions: positions})
+	}
+
+	return boards, errs
+}
+
+// ValidateBoards checks every board file in fsys and returns all the
+// problems found, along with the number of valid boards.
+func ValidateBoards(fsys fs.FS) (int, []error) {
+	boards, errs := readBoardFiles(fsys)
+	return len(boards), errs
+}
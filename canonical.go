@@ -0,0 +1,87 @@
+package main
+
+const keyDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// solutionKeyInto writes the SolutionKey of a full solution into key, which
+// must have one byte per row. Unlike SolutionKey it does not sort.
+func solutionKeyInto(key []byte, positions []Position) {
+	for _, pos := range positions {
+		key[pos.Row] = keyDigits[pos.Col]
+	}
+}
+
+// CanonicalKey returns the smallest SolutionKey among the eight transforms
+// of a full solution. All variants of a solution share it, so it identifies
+// the solution's symmetry class.
+func CanonicalKey(positions []Position, size int) string {
+	best := make([]byte, size)
+	candidate := make([]byte, size)
+
+	solutionKeyInto(best, positions)
+	for transform := TransformRot90; transform <= TransformMirrorAD; transform++ {
+		for _, pos := range positions {
+			moved := transform.apply(pos, size)
+			candidate[moved.Row] = keyDigits[moved.Col]
+		}
+		if string(candidate) < string(best) {
+			best, candidate = candidate, best
+		}
+	}
+	return string(best)
+}
+
+// SolutionIndex numbers the fundamental solutions of one board size by
+// their canonical keys, so that finding which fundamental a solution is a
+// variant of takes a single map lookup.
+type SolutionIndex struct {
+	fundamentals [][]Position
+	size         int
+	numbers      map[string]int
+}
+
+func NewSolutionIndex(fundamentals [][]Position, size int) *SolutionIndex {
+	idx := &SolutionIndex{
+		fundamentals: fundamentals,
+		size:         size,
+		numbers:      make(map[string]int, len(fundamentals)),
+	}
+	for i, fundamental := range fundamentals {
+		idx.numbers[CanonicalKey(fundamental, size)] = i + 1
+	}
+	return idx
+}
+
+// Len returns the number of fundamental solutions.
+func (idx *SolutionIndex) Len() int {
+	return len(idx.fundamentals)
+}
+
+// Find returns the number of the fundamental solution that board is a
+// variant of, or -1 if there is none.
+func (idx *SolutionIndex) Find(board []Position) int {
+	if len(board) != idx.size {
+		return -1
+	}
+	for _, pos := range board {
+		if pos.Row < 0 || pos.Row >= idx.size || pos.Col < 0 || pos.Col >= idx.size {
+			return -1
+		}
+	}
+	if number, ok := idx.numbers[CanonicalKey(board, idx.size)]; ok {
+		return number
+	}
+	return -1
+}
+
+// Match returns the number of the fundamental solution that board is a
+// variant of, with the transforms that turn the fundamental into board,
+// or -1 if there is none.
+func (idx *SolutionIndex) Match(board []Position) (int, []Transform) {
+	number := idx.Find(board)
+	if number == -1 {
+		return -1, nil
+	}
+
+	symmetries := NewSymmetries(idx.fundamentals[number-1], idx.size)
+	return number, symmetries.Matches(board)
+}
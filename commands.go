@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type subcommand struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var subcommands = []subcommand{
+	{"solve", "solve -size N [-limit K] [-format text|json] [-board FILE]", runSolve},
+	{"info", "info -size N [-boards DIR]", runInfo},
+	{"validate-boards", "validate-boards DIR", runValidateBoards},
+	{"convert", "convert -from FORMAT -to FORMAT [-size N] [BOARD]", runConvert},
+	{"keys", "keys [-keys PRESET]", runKeys},
+}
+
+// runSubcommand runs the subcommand named by args[0], if there is one.
+// It reports false when args do not start with a subcommand name.
+func runSubcommand(args []string) bool {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return false
+	}
+
+	for _, cmd := range subcommands {
+		if cmd.name == args[0] {
+			if err := cmd.run(args[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return true
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Error: unknown command %q\n", args[0])
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(os.Stderr, "  queens %s\n", cmd.usage)
+	}
+	os.Exit(1)
+	return true
+}
+
+func checkBoardSize(size int) error {
+	if size < MinBoardSize || size > MaxBoardSize {
+		return fmt.Errorf("-size must be between %d and %d", MinBoardSize, MaxBoardSize)
+	}
+	return nil
+}
+
+type solutionJSON struct {
+	Columns []int    `json:"columns"`
+	Rows    []string `json:"rows"`
+}
+
+func runSolve(args []string) error {
+	fs := flag.NewFlagSet("solve", flag.ExitOnError)
+	size := fs.Int("size", DefaultBoardSize, fmt.Sprintf("board size N (%d-%d)", MinBoardSize, MaxBoardSize))
+	limit := fs.Int("limit", 0, "stop after K solutions (0 prints all)")
+	format := fs.String("format", "text", "output format: text or json")
+	board := fs.String("board", "", "board file with a partial placement to complete")
+	fs.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	var positions []Position
+	if *board != "" {
+		content, err := os.ReadFile(*board)
+		if err != nil {
+			return err
+		}
+
+		loaded, err := ParseGrid(string(content))
+		if err != nil {
+			return fmt.Errorf("%s: %w", *board, err)
+		}
+		positions = loaded.queens
+		boardSize := loaded.Size()
+		// The board gives the size, so -size can only repeat it.
+		sizeSet := false
+		fs.Visit(func(f *flag.Flag) { sizeSet = sizeSet || f.Name == "size" })
+		if sizeSet && *size != boardSize {
+			return fmt.Errorf("-size %d doesn't match the %dx%d board in %s", *size, boardSize, boardSize, *board)
+		}
+		*size = boardSize
+	}
+
+	if err := checkBoardSize(*size); err != nil {
+		return err
+	}
+
+	queens := NewQueensFrom(*size, positions)
+
+	solver, err := NewSolverFrom(&queens)
+	if err != nil {
+		return err
+	}
+
+	// Solutions are printed as they are found: there can be far too many
+	// to hold them all. The JSON array is written a solution at a time.
+	out := bufio.NewWriter(os.Stdout)
+	count := 0
+	var encodeErr error
+	solver.Each(func(solution []Position) bool {
+		if *format == "json" {
+			entry := solutionJSON{}
+			for _, pos := range solution {
+				entry.Columns = append(entry.Columns, pos.Col)
+			}
+			entry.Rows = strings.Fields(FormatBoard(solution, queens.Size()))
+			data, err := json.MarshalIndent(entry, "  ", "  ")
+			if err != nil {
+				encodeErr = err
+				return false
+			}
+			if count == 0 {
+				out.WriteString("[\n  ")
+			} else {
+				out.WriteString(",\n  ")
+			}
+			out.Write(data)
+		} else {
+			if count > 0 {
+				out.WriteString("\n")
+			}
+			out.WriteString(FormatBoard(solution, queens.Size()))
+		}
+		count++
+		return *limit == 0 || count < *limit
+	})
+	if encodeErr != nil {
+		return encodeErr
+	}
+
+	if *format == "json" {
+		if count == 0 {
+			out.WriteString("[]\n")
+		} else {
+			out.WriteString("\n]\n")
+		}
+	}
+	return out.Flush()
+}
+
+func runInfo(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	size := fs.Int("size", DefaultBoardSize, fmt.Sprintf("board size N (%d-%d)", MinBoardSize, maxGeneratedSize))
+	boardsDir := fs.String("boards", "", "directory of board files numbering the fundamental solutions (default: built-in boards)")
+	fs.Parse(args)
+
+	if err := checkBoardSize(*size); err != nil {
+		return err
+	}
+	if *size > maxGeneratedSize {
+		return fmt.Errorf("fundamental solutions are only generated up to size %d", maxGeneratedSize)
+	}
+
+	fundamentals, err := LoadFundamentals(*boardsDir, *size)
+	if err != nil {
+		return err
+	}
+
+	orbits := OrbitSizes(fundamentals, *size)
+	total := 0
+	for _, orbit := range orbits {
+		total += orbit
+	}
+
+	fmt.Printf("%d-Queens: %d fundamental and %d distinct solutions\n\n", *size, len(fundamentals), total)
+	width := max(*size, len("Columns"))
+	fmt.Printf("%4s  %-*s  %5s  %s\n", "#", width, "Columns", "Orbit", "Stabilizer")
+	for i, fundamental := range fundamentals {
+		symmetries := NewSymmetries(fundamental, *size)
+
+		var stabilizer []string
+		for _, transform := range symmetries.Stabilizer() {
+			name, _ := transform.MarshalText()
+			stabilizer = append(stabilizer, string(name))
+		}
+
+		orbit := strconv.Itoa(orbits[i])
+		if orbits[i] < 8 {
+			orbit += "*"
+		}
+
+		fmt.Printf("%4s  %-*s  %5s  %s\n", fmt.Sprintf("%02d", i+1), width, SolutionKey(fundamental), orbit, strings.Join(stabilizer, ", "))
+	}
+
+	fmt.Printf("\n%s\n", FormatOrbitSum(orbits))
+	fmt.Println("Each fundamental has 8 rotations and reflections, but a solution that")
+	fmt.Println("a transform leaves unchanged has fewer distinct ones (starred):")
+	fmt.Println("its orbit is 8 divided by the size of its stabilizer.")
+	return nil
+}
+
+func runValidateBoards(args []string) error {
+	fs := flag.NewFlagSet("validate-boards", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: queens validate-boards DIR")
+	}
+	dir := fs.Arg(0)
+
+	boardsFS, err := BoardsFS(dir)
+	if err != nil {
+		return err
+	}
+
+	valid, errs := ValidateBoards(boardsFS)
+	for _, err := range errs {
+		fmt.Println(boardsDirError(dir, err))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d invalid board files in %s", len(errs), dir)
+	}
+
+	fmt.Printf("%s: %d valid board files\n", dir, valid)
+	return nil
+}
+
+func runConvert(args []string) error {
+	var names []string
+	for _, format := range boardFormats {
+		names = append(names, format.Name)
+	}
+	formatsUsage := "board format: " + strings.Join(names, ", ")
+
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := fs.String("from", "grid", formatsUsage)
+	to := fs.String("to", "grid", formatsUsage)
+	size := fs.Int("size", DefaultBoardSize, "board size N for formats that don't give it (algebraic)")
+	fs.Parse(args)
+
+	fromFormat, err := GetBoardFormat(*from)
+	if err != nil {
+		return err
+	}
+	toFormat, err := GetBoardFormat(*to)
+	if err != nil {
+		return err
+	}
+	if err := checkBoardSize(*size); err != nil {
+		return err
+	}
+
+	// The board is given as arguments, or read from stdin without them.
+	input := strings.Join(fs.Args(), " ")
+	if fs.NArg() == 0 {
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		input = string(content)
+	}
+
+	queens, err := fromFormat.Decode(input, *size)
+	if err != nil {
+		return err
+	}
+
+	output := toFormat.Encode(queens)
+	fmt.Print(output)
+	if !strings.HasSuffix(output, "\n") {
+		fmt.Println()
+	}
+	return nil
+}
+
+// runKeys checks keys.json and prints the keys bound to each action.
+func runKeys(args []string) error {
+	fs := flag.NewFlagSet("keys", flag.ExitOnError)
+	preset := fs.String("keys", "", "key preset: "+strings.Join(KeyPresetNames(), ", "))
+	fs.Parse(args)
+
+	keyFile, err := LoadKeyFile()
+	if err != nil {
+		return err
+	}
+
+	keymap, errs := NewKeymap(*preset, keyFile)
+	for _, err := range errs {
+		fmt.Printf("%s: %v\n", GetKeysPath(), err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d problems in the key bindings", len(errs))
+	}
+
+	for _, row := range controlsRows {
+		for _, code := range row.codes {
+			name, _ := code.MarshalText()
+			fmt.Printf("%-14s %s\n", name, strings.Join(keymap.Keys(code), " "))
+		}
+	}
+	for _, code := range []Code{CodeHome, CodeEnd, CodePageUp, CodePageDown} {
+		name, _ := code.MarshalText()
+		fmt.Printf("%-14s %s\n", name, strings.Join(keymap.Keys(code), " "))
+	}
+	return nil
+}
@@ -2,25 +2,73 @@ package main
 
 import (
 	"bufio"
+	_ "embed"
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 )
 
 type Config struct {
-	Players map[string]struct {
-		Solved [12]int `json:"solved"`
-	} `json:"players"`
+	Players map[string]PlayerData `json:"players"`
 }
 
+// Progress holds the solutions a player has found on one board size: a flag
+// per fundamental solution, the SolutionKey of every distinct solution, and
+// the orientations each fundamental was found in, keyed by its number.
+type Progress struct {
+	Solved       []int                  `json:"solved"`
+	Found        []string               `json:"found,omitempty"`
+	Orientations map[string][]Transform `json:"orientations,omitempty"`
+}
+
+// PlayerData keeps the default 8x8 progress at the top level, as older
+// results.json files have it, and every other board size under "sizes".
+type PlayerData struct {
+	Progress
+	Sizes    map[string]Progress `json:"sizes,omitempty"`
+	Hints    int                 `json:"hints,omitempty"`
+	Commands []string            `json:"commands,omitempty"`
+}
+
+func (p *PlayerData) progress(size int) Progress {
+	if size == DefaultBoardSize {
+		return p.Progress
+	}
+	return p.Sizes[strconv.Itoa(size)]
+}
+
+func (p *PlayerData) setProgress(size int, progress Progress) {
+	if size == DefaultBoardSize {
+		p.Progress = progress
+		return
+	}
+	if p.Sizes == nil {
+		p.Sizes = make(map[string]Progress)
+	}
+	p.Sizes[strconv.Itoa(size)] = progress
+}
+
+// Prize is one line of prizes.txt: "cents,solutions,label". The solutions
+// field counts fundamental solutions, or distinct ones when followed by the
+// word "distinct", as in "300,92 distinct,Find all 92 solutions".
 type Prize struct {
 	Cents     int
 	Solutions int
+	Distinct  bool
 	Label     string
 }
 
+// Won reports whether the prize is earned with the given solution counts.
+func (p Prize) Won(fundamentals, distinct int) bool {
+	if p.Distinct {
+		return distinct >= p.Solutions
+	}
+	return fundamentals >= p.Solutions
+}
+
 func GetConfigPath() string {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -36,13 +84,9 @@ func LoadConfig(playerName string) (*Config, error) {
 	if err != nil {
 		if os.IsNotExist(err) {
 			config := &Config{
-				Players: make(map[string]struct {
-					Solved [12]int `json:"solved"`
-				}),
+				Players: make(map[string]PlayerData),
 			}
-			config.Players[playerName] = struct {
-				Solved [12]int `json:"solved"`
-			}{}
+			config.Players[playerName] = PlayerData{}
 			return config, nil
 		}
 		return nil, err
@@ -54,15 +98,11 @@ func LoadConfig(playerName string) (*Config, error) {
 	}
 
 	if config.Players == nil {
-		config.Players = make(map[string]struct {
-			Solved [12]int `json:"solved"`
-		})
+		config.Players = make(map[string]PlayerData)
 	}
 
 	if _, exists := config.Players[playerName]; !exists {
-		config.Players[playerName] = struct {
-			Solved [12]int `json:"solved"`
-		}{}
+		config.Players[playerName] = PlayerData{}
 	}
 
 	return &config, nil
@@ -84,16 +124,99 @@ func SaveConfig(config *Config) error {
 	return os.WriteFile(configPath, data, 0644)
 }
 
-func GetPlayerData(config *Config, playerName string) [12]int {
+// GetPlayerData returns the player's solved flags for the given board size,
+// padded to the number of fundamental solutions on that board.
+func GetPlayerData(config *Config, playerName string, size int, total int) []int {
+	solved := make([]int, total)
+	if player, exists := config.Players[playerName]; exists {
+		copy(solved, player.progress(size).Solved)
+	}
+	return solved
+}
+
+// HasProgress reports whether the player has found any fundamental
+// solution on the given board size.
+func HasProgress(config *Config, playerName string, size int) bool {
+	player, exists := config.Players[playerName]
+	if !exists {
+		return false
+	}
+	progress := player.progress(size)
+	return slices.Contains(progress.Solved, 1) || len(progress.Orientations) > 0
+}
+
+func SetPlayerData(config *Config, playerName string, size int, solved []int) {
+	playerData := config.Players[playerName]
+	progress := playerData.progress(size)
+	progress.Solved = solved
+	playerData.setProgress(size, progress)
+	config.Players[playerName] = playerData
+}
+
+// GetFoundSolutions returns the set of distinct solutions the player has
+// found on the given board size.
+func GetFoundSolutions(config *Config, playerName string, size int) map[string]bool {
+	found := make(map[string]bool)
 	if player, exists := config.Players[playerName]; exists {
-		return player.Solved
+		for _, key := range player.progress(size).Found {
+			found[key] = true
+		}
 	}
-	return [12]int{}
+	return found
 }
 
-func SetPlayerData(config *Config, playerName string, solved [12]int) {
+// AddFoundSolution records a distinct solution and reports whether it is new.
+func AddFoundSolution(config *Config, playerName string, size int, key string) bool {
 	playerData := config.Players[playerName]
-	playerData.Solved = solved
+	progress := playerData.progress(size)
+	if slices.Contains(progress.Found, key) {
+		return false
+	}
+	progress.Found = append(progress.Found, key)
+	playerData.setProgress(size, progress)
+	config.Players[playerName] = playerData
+	return true
+}
+
+// AddOrientations records the orientations a fundamental solution was found
+// in and reports whether any of them is new.
+func AddOrientations(config *Config, playerName string, size int, matchNum int, transforms []Transform) bool {
+	playerData := config.Players[playerName]
+	progress := playerData.progress(size)
+	if progress.Orientations == nil {
+		progress.Orientations = make(map[string][]Transform)
+	}
+
+	key := strconv.Itoa(matchNum)
+	changed := false
+	for _, transform := range transforms {
+		if !slices.Contains(progress.Orientations[key], transform) {
+			progress.Orientations[key] = append(progress.Orientations[key], transform)
+			changed = true
+		}
+	}
+	slices.Sort(progress.Orientations[key])
+
+	playerData.setProgress(size, progress)
+	config.Players[playerName] = playerData
+	return changed
+}
+
+func AddHintUsed(config *Config, playerName string) {
+	playerData := config.Players[playerName]
+	playerData.Hints++
+	config.Players[playerName] = playerData
+}
+
+// GetCommandHistory returns the lines the player has entered in command
+// mode, oldest first.
+func GetCommandHistory(config *Config, playerName string) []string {
+	return config.Players[playerName].Commands
+}
+
+func SetCommandHistory(config *Config, playerName string, history []string) {
+	playerData := config.Players[playerName]
+	playerData.Commands = history
 	config.Players[playerName] = playerData
 }
 
@@ -105,6 +228,9 @@ func GetPrizesPath() string {
 	return filepath.Join(home, ".queens", "prizes.txt")
 }
 
+//go:embed prizes.txt
+var defaultPrizes []byte
+
 func CreateDefaultPrizes() error {
 	prizesPath := GetPrizesPath()
 
@@ -113,14 +239,7 @@ func CreateDefaultPrizes() error {
 		return err
 	}
 
-	defaultContent := `020,1,Find one solution
-020,2,Find two solutions
-050,5,Find 5   solutions
-100,7,Find 7   solutions
-300,12,Find 12  solutions
-`
-
-	return os.WriteFile(prizesPath, []byte(defaultContent), 0644)
+	return os.WriteFile(prizesPath, defaultPrizes, 0644)
 }
 
 func LoadPrizes() ([]Prize, error) {
@@ -160,16 +279,30 @@ func LoadPrizes() ([]Prize, error) {
 			continue
 		}
 
-		solutions, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		solutionsField := strings.Fields(parts[1])
+		if len(solutionsField) == 0 || len(solutionsField) > 2 {
+			continue
+		}
+
+		solutions, err := strconv.Atoi(solutionsField[0])
 		if err != nil {
 			continue
 		}
 
+		distinct := false
+		if len(solutionsField) == 2 {
+			if solutionsField[1] != "distinct" {
+				continue
+			}
+			distinct = true
+		}
+
 		label := parts[2]
 
 		prizes = append(prizes, Prize{
 			Cents:     cents,
 			Solutions: solutions,
+			Distinct:  distinct,
 			Label:     label,
 		})
 	}
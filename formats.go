@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrBadFormat      = errors.New("unknown board format")
+	ErrBadPermutation = errors.New("invalid permutation")
+	ErrBadFEN         = errors.New("invalid placement string")
+	ErrBadSquare      = errors.New("invalid square")
+	ErrBadGrid        = errors.New("invalid grid")
+	ErrBadJSON        = errors.New("invalid board JSON")
+)
+
+// Boards are written in chess terms in every format but the grid: files a,
+// b, c... are the columns from the left, and ranks 1, 2, 3... are the rows
+// counted from the bottom, so the top row is rank N.
+
+func (q *Queens) rank(row int) int {
+	return q.size - row
+}
+
+func rowOfRank(rank, size int) int {
+	return size - rank
+}
+
+func fileName(col int) string {
+	return string(rune('a' + col))
+}
+
+// Square returns the algebraic name of a cell, like "e4".
+func (q *Queens) Square(row, col int) string {
+	return fileName(col) + strconv.Itoa(q.rank(row))
+}
+
+// ParseSquare reads an algebraic square name like "e4" on a board of the
+// given size.
+func ParseSquare(square string, size int) (Position, error) {
+	square = strings.ToLower(strings.TrimSpace(square))
+	if len(square) < 2 || square[0] < 'a' || square[0] >= byte('a'+size) {
+		return Position{}, fmt.Errorf("%w %q", ErrBadSquare, square)
+	}
+
+	rank, err := strconv.Atoi(square[1:])
+	if err != nil || rank < 1 || rank > size {
+		return Position{}, fmt.Errorf("%w %q", ErrBadSquare, square)
+	}
+
+	return Position{Row: rowOfRank(rank, size), Col: int(square[0] - 'a')}, nil
+}
+
+// Permutation writes the rank of the queen in each file, from file a on,
+// as in "46827135". Files without a queen are written as '.', and boards
+// of 10 or more use commas between ranks. A file holding several queens,
+// as hard mode allows, gives only its top one.
+func (q *Queens) Permutation() string {
+	ranks := make([]string, q.size)
+	for i := range ranks {
+		ranks[i] = "."
+	}
+	for row := q.size - 1; row >= 0; row-- {
+		for col := 0; col < q.size; col++ {
+			if q.HasQueen(row, col) {
+				ranks[col] = strconv.Itoa(q.rank(row))
+			}
+		}
+	}
+
+	if q.size < 10 {
+		return strings.Join(ranks, "")
+	}
+	return strings.Join(ranks, ",")
+}
+
+// ParsePermutation reads a board written by Permutation. The board size is
+// the number of files.
+func ParsePermutation(s string) (Queens, error) {
+	s = strings.TrimSpace(s)
+
+	var ranks []string
+	if strings.ContainsAny(s, ", ") {
+		ranks = strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+	} else {
+		ranks = strings.Split(s, "")
+	}
+
+	size := len(ranks)
+	if size < MinBoardSize || size > MaxBoardSize {
+		return Queens{}, fmt.Errorf("%w: %d files, expected %d to %d", ErrBadPermutation, size, MinBoardSize, MaxBoardSize)
+	}
+
+	var positions []Position
+	for col, field := range ranks {
+		if field == "." {
+			continue
+		}
+		rank, err := strconv.Atoi(field)
+		if err != nil || rank < 1 || rank > size {
+			return Queens{}, fmt.Errorf("%w: bad rank %q in file %s", ErrBadPermutation, field, fileName(col))
+		}
+		positions = append(positions, Position{Row: rowOfRank(rank, size), Col: col})
+	}
+
+	return NewQueensFrom(size, positions), nil
+}
+
+// FEN writes the board like the piece placement field of chess FEN: ranks
+// from the top down separated by '/', each a run of queens 'Q' and counts
+// of empty cells, as in "2Q5/4Q3/1Q6/7Q/Q7/6Q1/3Q4/5Q2".
+func (q *Queens) FEN() string {
+	ranks := make([]string, q.size)
+	for row := 0; row < q.size; row++ {
+		var rank strings.Builder
+		empty := 0
+		for col := 0; col < q.size; col++ {
+			if !q.HasQueen(row, col) {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				rank.WriteString(strconv.Itoa(empty))
+				empty = 0
+			}
+			rank.WriteByte('Q')
+		}
+		if empty > 0 {
+			rank.WriteString(strconv.Itoa(empty))
+		}
+		ranks[row] = rank.String()
+	}
+	return strings.Join(ranks, "/")
+}
+
+// ParseFEN reads a board written by FEN. The board size is the number of
+// ranks, and each rank must cover exactly that many cells.
+func ParseFEN(s string) (Queens, error) {
+	ranks := strings.Split(strings.TrimSpace(s), "/")
+	size := len(ranks)
+	if size < MinBoardSize || size > MaxBoardSize {
+		return Queens{}, fmt.Errorf("%w: %d ranks, expected %d to %d", ErrBadFEN, size, MinBoardSize, MaxBoardSize)
+	}
+
+	var positions []Position
+	for row, rank := range ranks {
+		col := 0
+		for i := 0; i < len(rank); {
+			if rank[i] == 'Q' || rank[i] == 'q' {
+				positions = append(positions, Position{Row: row, Col: col})
+				col++
+				i++
+				continue
+			}
+
+			j := i
+			for j < len(rank) && rank[j] >= '0' && rank[j] <= '9' {
+				j++
+			}
+			empty, err := strconv.Atoi(rank[i:j])
+			if err != nil || empty == 0 {
+				return Queens{}, fmt.Errorf("%w: unexpected %q in rank %d", ErrBadFEN, rank[i:], size-row)
+			}
+			col += empty
+			i = j
+		}
+
+		if col != size {
+			return Queens{}, fmt.Errorf("%w: rank %d covers %d cells, expected %d", ErrBadFEN, size-row, col, size)
+		}
+	}
+
+	return NewQueensFrom(size, positions), nil
+}
+
+// Algebraic lists the queens' squares by file, as in "a4 b6 c8 d2 e7 f1 g3 h5".
+func (q *Queens) Algebraic() string {
+	var squares []string
+	for col := 0; col < q.size; col++ {
+		for row := q.size - 1; row >= 0; row-- {
+			if q.HasQueen(row, col) {
+				squares = append(squares, q.Square(row, col))
+			}
+		}
+	}
+	return strings.Join(squares, " ")
+}
+
+// ParseAlgebraic reads a list of squares separated by spaces or commas.
+// The squares don't give the board size, so it is passed in.
+func ParseAlgebraic(s string, size int) (Queens, error) {
+	var positions []Position
+	for _, square := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		pos, err := ParseSquare(square, size)
+		if err != nil {
+			return Queens{}, err
+		}
+		positions = append(positions, pos)
+	}
+	return NewQueensFrom(size, positions), nil
+}
+
+// Grid draws the board in the boards/*.txt format.
+func (q *Queens) Grid() string {
+	return FormatBoard(q.queens, q.size)
+}
+
+// ParseGrid reads a board in the boards/*.txt format. Unlike ParseSolution
+// it accepts any placement, but every row must be as long as the board.
+func ParseGrid(s string) (Queens, error) {
+	lines := strings.Split(strings.TrimRight(s, "\r\n"), "\n")
+	size := len(lines)
+	if size < MinBoardSize || size > MaxBoardSize {
+		return Queens{}, fmt.Errorf("%w: %d rows, expected %d to %d", ErrBadGrid, size, MinBoardSize, MaxBoardSize)
+	}
+
+	var positions []Position
+	for row, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if len(line) != size {
+			return Queens{}, fmt.Errorf("%w: row %d has %d cells, expected %d", ErrBadGrid, row+1, len(line), size)
+		}
+		for col, char := range line {
+			switch char {
+			case '.':
+			case 'Q':
+				positions = append(positions, Position{Row: row, Col: col})
+			default:
+				return Queens{}, fmt.Errorf("%w: unexpected %q in row %d", ErrBadGrid, char, row+1)
+			}
+		}
+	}
+
+	return NewQueensFrom(size, positions), nil
+}
+
+type queensJSON struct {
+	Size   int      `json:"size"`
+	Queens []string `json:"queens"`
+}
+
+// MarshalJSON writes the board as its size and the queens' squares, as in
+// {"size":8,"queens":["a4","b6",...]}.
+func (q Queens) MarshalJSON() ([]byte, error) {
+	squares := strings.Fields(q.Algebraic())
+	if squares == nil {
+		squares = []string{}
+	}
+	return json.Marshal(queensJSON{Size: q.size, Queens: squares})
+}
+
+func (q *Queens) UnmarshalJSON(data []byte) error {
+	var board queensJSON
+	if err := json.Unmarshal(data, &board); err != nil {
+		return err
+	}
+	if board.Size < MinBoardSize || board.Size > MaxBoardSize {
+		return fmt.Errorf("size %d, expected %d to %d", board.Size, MinBoardSize, MaxBoardSize)
+	}
+
+	loaded, err := ParseAlgebraic(strings.Join(board.Queens, " "), board.Size)
+	if err != nil {
+		return err
+	}
+	*q = loaded
+	return nil
+}
+
+// ParseJSON reads a board written by MarshalJSON.
+func ParseJSON(s string) (Queens, error) {
+	var q Queens
+	if err := json.Unmarshal([]byte(s), &q); err != nil {
+		return Queens{}, fmt.Errorf("%w: %w", ErrBadJSON, err)
+	}
+	return q, nil
+}
+
+// BoardFormat is one of the formats boards can be converted between.
+type BoardFormat struct {
+	Name   string
+	Encode func(q Queens) string
+	// Decode reads a board; size is only used by formats that don't say it.
+	Decode func(s string, size int) (Queens, error)
+}
+
+var boardFormats = []BoardFormat{
+	{
+		Name:   "grid",
+		Encode: func(q Queens) string { return q.Grid() },
+		Decode: func(s string, size int) (Queens, error) { return ParseGrid(s) },
+	},
+	{
+		Name:   "perm",
+		Encode: func(q Queens) string { return q.Permutation() },
+		Decode: func(s string, size int) (Queens, error) { return ParsePermutation(s) },
+	},
+	{
+		Name:   "fen",
+		Encode: func(q Queens) string { return q.FEN() },
+		Decode: func(s string, size int) (Queens, error) { return ParseFEN(s) },
+	},
+	{
+		Name:   "algebraic",
+		Encode: func(q Queens) string { return q.Algebraic() },
+		Decode: ParseAlgebraic,
+	},
+	{
+		Name: "json",
+		Encode: func(q Queens) string {
+			data, _ := json.Marshal(q)
+			return string(data)
+		},
+		Decode: func(s string, size int) (Queens, error) { return ParseJSON(s) },
+	},
+}
+
+func GetBoardFormat(name string) (BoardFormat, error) {
+	for _, format := range boardFormats {
+		if format.Name == name {
+			return format, nil
+		}
+	}
+	return BoardFormat{}, fmt.Errorf("%w %q", ErrBadFormat, name)
+}
+
+// DetectBoardFormat guesses the format of a one-line board description,
+// as typed after :load.
+func DetectBoardFormat(s string) BoardFormat {
+	s = strings.TrimSpace(s)
+	name := "perm"
+	switch {
+	case strings.HasPrefix(s, "{"):
+		name = "json"
+	case strings.Contains(s, "/"):
+		name = "fen"
+	case s != "" && s[0] >= 'a' && s[0] <= 'z':
+		name = "algebraic"
+	}
+	format, _ := GetBoardFormat(name)
+	return format
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Game is the state of one interactive session.
+type Game struct {
+	terminal     *Terminal
+	renderer     Renderer
+	boardsDir    string
+	config       *Config
+	player       string
+	prizes       []Prize
+	fundamentals [][]Position
+	index        *SolutionIndex
+	orbits       []int
+	noExit       bool
+	hard         bool
+	labels       LabelStyle
+	// labelsChosen is whether the labels were chosen in this session, with
+	// -labels or :labels, which then win over those of the games restored.
+	labelsChosen bool
+
+	queens       Queens
+	cursorRow    int
+	cursorCol    int
+	showHelp     bool
+	showVariants bool
+	commandMode  bool
+	commandLine  LineEditor
+	commandError string
+	hint         *Position
+	message      string
+	reachable    int
+	// solverBoard is the board :solve or :load filled in. Until a queen
+	// is placed off it, solving the board doesn't count as found.
+	solverBoard []Position
+
+	// boardX and boardY are the 1-based screen column and row of the
+	// board's top-left corner when last rendered, for mapping mouse events.
+	// They stay 0 with renderers that don't know where the board is.
+	boardX int
+	boardY int
+}
+
+// NewGame starts a session on an empty board. labels is nil to take the
+// labels of the games restored.
+func NewGame(terminal *Terminal, renderer Renderer, boardsDir string, config *Config, player string, size int, fundamentals [][]Position, prizes []Prize, noExit, hard bool, labels *LabelStyle) *Game {
+	g := &Game{
+		terminal:     terminal,
+		renderer:     renderer,
+		boardsDir:    boardsDir,
+		config:       config,
+		player:       player,
+		prizes:       prizes,
+		fundamentals: fundamentals,
+		index:        NewSolutionIndex(fundamentals, size),
+		orbits:       OrbitSizes(fundamentals, size),
+		noExit:       noExit,
+		hard:         hard,
+		queens:       NewQueens(size),
+		reachable:    -1,
+		commandLine:  NewLineEditor(GetCommandHistory(config, player)),
+	}
+	if labels != nil {
+		g.chooseLabels(*labels)
+	}
+	return g
+}
+
+// chooseLabels sets the labels for the rest of the session.
+func (g *Game) chooseLabels(style LabelStyle) {
+	g.labels = style
+	g.labelsChosen = true
+}
+
+func (g *Game) solved() []int {
+	return GetPlayerData(g.config, g.player, g.queens.Size(), len(g.fundamentals))
+}
+
+// maxReachableCount caps how many completions the status line counts, so
+// large, mostly empty boards don't stall every placement.
+const maxReachableCount = 1000
+
+// maxReachableNodes caps the partial placements tried while counting, since
+// on large boards finding that there are few completions, or none, can take
+// far longer than finding many.
+const maxReachableNodes = 1_000_000
+
+// reachableUnknown is the reachable count when counting ran out of nodes.
+const reachableUnknown = -2
+
+// countReachable counts the solutions that the current placement can still
+// be completed to, or reachableUnknown if that takes too long. Hard mode
+// shows no such help.
+func (g *Game) countReachable() {
+	g.reachable = -1
+	if g.hard {
+		return
+	}
+
+	solver, err := NewSolverFrom(&g.queens)
+	if err != nil {
+		g.reachable = 0
+		return
+	}
+	count, ok := solver.CountWithin(maxReachableCount, maxReachableNodes)
+	if !ok {
+		count = reachableUnknown
+	}
+	g.reachable = count
+}
+
+func (g *Game) found() map[string]bool {
+	return GetFoundSolutions(g.config, g.player, g.queens.Size())
+}
+
+func (g *Game) render() {
+	drawScreen(g.renderer, g)
+	if locator, ok := g.renderer.(boardLocator); ok {
+		g.boardX, g.boardY = locator.BoardOrigin()
+	}
+}
+
+// boardChanged is called after every change to the queens on the board.
+func (g *Game) boardChanged() {
+	g.hint = nil
+	g.countReachable()
+
+	if g.solverBoard != nil && !containsPositions(g.solverBoard, g.queens.queens) {
+		g.solverBoard = nil
+	}
+	switch {
+	case g.solverBoard == nil:
+		g.message = checkAndUpdateSolution(g.queens, g.config, g.player, g.index)
+	case g.queens.IsSolved():
+		g.message = solvedForYou
+	default:
+		g.message = ""
+	}
+	g.Autosave()
+}
+
+const solvedForYou = "Filled in for you; it doesn't count as found"
+
+// containsPositions reports whether every position in some is in all.
+func containsPositions(all, some []Position) bool {
+	for _, pos := range some {
+		if !slices.Contains(all, pos) {
+			return false
+		}
+	}
+	return true
+}
+
+// Handle applies one input command and reports whether the game should exit.
+func (g *Game) Handle(cmd Cmd) bool {
+	if cmd.Code == CodeResize {
+		if renderer, ok := g.renderer.(resizable); ok {
+			renderer.Resized()
+		}
+		g.render()
+		return false
+	}
+
+	g.commandError = ""
+
+	if g.commandMode {
+		return g.handleCommandLine(cmd)
+	}
+
+	switch cmd.Code {
+	case CodeExit:
+		return true
+
+	case CodeCommand:
+		g.setCommandMode(true)
+		g.render()
+
+	case CodeReset:
+		g.queens.Reset()
+		g.boardChanged()
+		g.cursorRow, g.cursorCol = 0, 0
+		g.render()
+
+	case CodeUndo:
+		if err := g.queens.Undo(); err == nil {
+			g.boardChanged()
+			g.render()
+		}
+
+	case CodeRedo:
+		if err := g.queens.Redo(); err == nil {
+			g.boardChanged()
+			g.render()
+		}
+
+	case CodeHelp:
+		if !g.hard {
+			g.showHelp = !g.showHelp
+			g.render()
+		}
+
+	case CodeVariants:
+		g.showVariants = !g.showVariants
+		g.render()
+
+	case CodeHint:
+		g.showHint()
+		g.render()
+
+	case CodeSymbolBlack:
+		g.queens.SetSymbol(SymbolBlack)
+		g.render()
+
+	case CodeSymbolWhite:
+		g.queens.SetSymbol(SymbolWhite)
+		g.render()
+
+	case CodeSymbolAscii:
+		g.queens.SetSymbol(SymbolAscii)
+		g.render()
+
+	case CodePlace:
+		g.toggleQueen()
+		g.render()
+
+	case CodeUp:
+		if g.cursorRow > 0 {
+			g.cursorRow--
+			g.render()
+		}
+
+	case CodeDown:
+		if g.cursorRow < g.queens.Size()-1 {
+			g.cursorRow++
+			g.render()
+		}
+
+	case CodeLeft:
+		if g.cursorCol > 0 {
+			g.cursorCol--
+			g.render()
+		}
+
+	case CodeRight:
+		if g.cursorCol < g.queens.Size()-1 {
+			g.cursorCol++
+			g.render()
+		}
+
+	case CodeHome:
+		g.cursorCol = 0
+		g.render()
+
+	case CodeEnd:
+		g.cursorCol = g.queens.Size() - 1
+		g.render()
+
+	case CodeClick:
+		if pos, ok := g.cellAt(cmd.Data); ok {
+			g.cursorRow, g.cursorCol = pos.Row, pos.Col
+			g.toggleQueen()
+			g.render()
+		}
+
+	case CodeHover:
+		if pos, ok := g.cellAt(cmd.Data); ok && (pos.Row != g.cursorRow || pos.Col != g.cursorCol) {
+			g.cursorRow, g.cursorCol = pos.Row, pos.Col
+			g.render()
+		}
+
+	case CodePageUp:
+		g.cursorRow = 0
+		g.render()
+
+	case CodePageDown:
+		g.cursorRow = g.queens.Size() - 1
+		g.render()
+
+	case CodeNone:
+	}
+	return false
+}
+
+// handleCommandLine applies one input command in command mode, editing
+// the line being typed until it is entered or cancelled.
+func (g *Game) handleCommandLine(cmd Cmd) bool {
+	line := &g.commandLine
+	switch cmd.Code {
+	case CodeExit, CodeCancelCommand:
+		g.setCommandMode(false)
+
+	case CodePlace:
+		command := line.String()
+		line.AddHistory(command)
+		SetCommandHistory(g.config, g.player, line.History())
+		SaveConfig(g.config)
+
+		g.setCommandMode(false)
+		quit := g.execute(":" + command)
+		g.render()
+		return quit
+
+	case CodeBackspace:
+		// Deleting the ':' leaves command mode, as in vi.
+		if line.String() == "" {
+			g.setCommandMode(false)
+		}
+		line.Backspace()
+
+	case CodeDelete:
+		line.Delete()
+
+	case CodeDeleteWord:
+		line.DeleteWord()
+
+	case CodeClearLine:
+		line.DeleteToStart()
+
+	case CodeLeft:
+		line.Left()
+
+	case CodeRight:
+		line.Right()
+
+	case CodeHome:
+		line.Home()
+
+	case CodeEnd:
+		line.End()
+
+	case CodeUp:
+		line.Previous()
+
+	case CodeDown:
+		line.Next()
+
+	case CodeComplete:
+		g.completeCommand()
+
+	case CodeChar:
+		if data, ok := cmd.Data.(rune); ok {
+			line.Insert(data)
+		}
+
+	default:
+		return false
+	}
+
+	g.render()
+	return false
+}
+
+// cellAt returns the board cell under a mouse event. Each cell is 3
+// columns wide and 1 row high, with a border line around it.
+func (g *Game) cellAt(data interface{}) (Position, bool) {
+	mouse, ok := data.(Mouse)
+	if !ok {
+		return Position{}, false
+	}
+
+	x := mouse.X - g.boardX
+	y := mouse.Y - g.boardY
+	if x <= 0 || y <= 0 || x%4 == 0 || y%2 == 0 {
+		return Position{}, false
+	}
+
+	pos := Position{Row: y / 2, Col: x / 4}
+	if !g.queens.inBounds(pos.Row, pos.Col) {
+		return Position{}, false
+	}
+	return pos, true
+}
+
+func (g *Game) setCommandMode(mode bool) {
+	g.commandMode = mode
+	g.terminal.SetCommandMode(mode)
+	g.commandLine.Reset()
+}
+
+func (g *Game) toggleQueen() {
+	if g.queens.HasQueen(g.cursorRow, g.cursorCol) {
+		g.queens.RemoveQueen(g.cursorRow, g.cursorCol)
+		g.boardChanged()
+		return
+	}
+
+	if g.queens.Count() >= g.queens.Size() {
+		return
+	}
+
+	var err error
+	if g.hard {
+		err = g.queens.PlaceQueenUnchecked(g.cursorRow, g.cursorCol)
+	} else {
+		err = g.queens.PlaceQueen(g.cursorRow, g.cursorCol)
+	}
+	if err == nil {
+		g.boardChanged()
+	}
+}
+
+// showHint highlights a cell that keeps the placement solvable, or says
+// that it no longer is. Like help, hints are off in hard mode.
+func (g *Game) showHint() {
+	if g.hard {
+		return
+	}
+
+	if g.queens.IsSolved() {
+		g.message = "The board is already solved"
+		return
+	}
+
+	hint, err := Hint(&g.queens, g.cursorRow)
+	if err != nil {
+		g.hint = nil
+		g.message = "No solution from here: remove some queens"
+		return
+	}
+
+	g.hint = &hint
+	g.message = "Hint: a queen here can still lead to a solution"
+	AddHintUsed(g.config, g.player)
+	SaveConfig(g.config)
+}
+
+func (g *Game) saved() SavedGame {
+	return SavedGame{
+		Board:     g.queens.Clone(),
+		History:   g.queens.History(),
+		CursorRow: g.cursorRow,
+		CursorCol: g.cursorCol,
+		Symbol:    g.queens.Symbol(),
+		Hard:      g.hard,
+		ShowHelp:  g.showHelp,
+		Labels:    g.labels,
+		Solver:    g.solverBoard,
+	}
+}
+
+// resize switches the game to an empty board of another size, loading the
+// fundamental solutions for it.
+func (g *Game) resize(size int) error {
+	fundamentals, err := LoadFundamentals(g.boardsDir, size)
+	if err != nil {
+		return err
+	}
+
+	symbol := g.queens.Symbol()
+	g.fundamentals = fundamentals
+	g.index = NewSolutionIndex(fundamentals, size)
+	g.orbits = OrbitSizes(fundamentals, size)
+	g.queens = NewQueens(size)
+	g.queens.SetSymbol(symbol)
+	g.cursorRow, g.cursorCol = 0, 0
+	g.hint = nil
+	g.solverBoard = nil
+	g.countReachable()
+	g.message = ""
+	return nil
+}
+
+// restore puts a saved game on the board, switching to its board size.
+func (g *Game) restore(save SavedGame) error {
+	size := save.Board.Size()
+	if size != g.queens.Size() {
+		if err := g.resize(size); err != nil {
+			return err
+		}
+	}
+
+	g.queens = save.Queens()
+	g.cursorRow = min(max(save.CursorRow, 0), size-1)
+	g.cursorCol = min(max(save.CursorCol, 0), size-1)
+	g.hard = save.Hard
+	g.showHelp = save.ShowHelp && !save.Hard
+	if !g.labelsChosen {
+		g.labels = save.Labels
+	}
+	g.solverBoard = save.Solver
+	g.hint = nil
+	g.countReachable()
+	g.message = ""
+	return nil
+}
+
+// Autosave keeps the game for the next session of the same player, or
+// says why it couldn't.
+func (g *Game) Autosave() {
+	if err := WriteAutosave(g.player, g.saved()); err != nil {
+		g.message = fmt.Sprintf("Autosave failed: %v", err)
+	}
+}
+
+// RestoreAutosave continues the player's last game on this board size, if
+// it was played in the same mode. Either way it counts the solutions still
+// reachable, so a new game needn't.
+func (g *Game) RestoreAutosave() {
+	save, err := ReadAutosave(g.player, g.queens.Size())
+	if err != nil || save.Hard != g.hard || g.restore(save) != nil {
+		g.countReachable()
+		return
+	}
+	if g.queens.Count() > 0 {
+		g.message = "Restored your last game"
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrUsage          = errors.New("usage")
+	ErrHardMode       = errors.New("not available in hard mode")
+)
+
+// gameCommand is one command that can be typed in command mode after ':'.
+// Commands taking a free-form argument, like a board to load, get it as a
+// single argument with its spaces kept.
+type gameCommand struct {
+	name     string
+	alias    string
+	usage    string
+	help     string
+	minArgs  int
+	maxArgs  int
+	freeForm bool
+	run      func(g *Game, args []string) error
+	// complete lists the values its arguments can take, for tab completion.
+	complete func(g *Game) []string
+}
+
+var gameCommands []gameCommand
+
+// The table is filled in init because :help reads it.
+func init() {
+	gameCommands = []gameCommand{
+		{name: "place", usage: "place SQUARE", help: "put a queen on a square, like e4, or on a cell like r3c2", minArgs: 1, maxArgs: 1, run: cmdPlace, complete: freeSquares},
+		{name: "remove", usage: "remove SQUARE", help: "take the queen off a square", minArgs: 1, maxArgs: 1, run: cmdRemove, complete: queenSquares},
+		{name: "goto", usage: "goto SQUARE", help: "move the cursor to a square", minArgs: 1, maxArgs: 1, run: cmdGoto, complete: allSquares},
+		{name: "reset", usage: "reset", help: "clear the board", run: cmdReset},
+		{name: "size", usage: "size N", help: fmt.Sprintf("play on an NxN board, %d to %d; fundamentals are tracked up to %d", MinBoardSize, MaxBoardSize, maxGeneratedSize), minArgs: 1, maxArgs: 1, run: cmdSize, complete: boardSizes},
+		{name: "symbol", usage: "symbol black|white|ascii", help: "change how queens are drawn", minArgs: 1, maxArgs: 1, run: cmdSymbol, complete: symbols},
+		{name: "labels", usage: "labels none|chess|numeric", help: "label the files and ranks around the board", minArgs: 1, maxArgs: 1, run: cmdLabels, complete: labelStyles},
+		{name: "hint", usage: "hint", help: "show a cell that can still lead to a solution", run: cmdHint},
+		{name: "solve", usage: "solve", help: "complete the board; it doesn't count as found", run: cmdSolve},
+		{name: "stats", usage: "stats", help: "show your progress on this board size", run: cmdStats},
+		{name: "load", usage: "load BOARD", help: "load a board like 46827135, a4 b6 ... or a FEN", minArgs: 1, maxArgs: 1, freeForm: true, run: cmdLoad},
+		{name: "write", alias: "w", usage: "w NAME", help: "save the game", minArgs: 1, maxArgs: 1, run: cmdWrite, complete: saveNames},
+		{name: "edit", alias: "e", usage: "e NAME", help: "open a saved game", minArgs: 1, maxArgs: 1, run: cmdEdit, complete: saveNames},
+		{name: "help", usage: "help [COMMAND]", help: "list the commands, or explain one", maxArgs: 1, run: cmdHelp, complete: commandNames},
+		{name: "quit", alias: "q", usage: "q", help: "exit the game", run: cmdQuit},
+	}
+}
+
+func findGameCommand(name string) (gameCommand, bool) {
+	for _, command := range gameCommands {
+		if command.name == name || (command.alias != "" && command.alias == name) {
+			return command, true
+		}
+	}
+	return gameCommand{}, false
+}
+
+// maxCompletionsShown caps how many matches tab completion lists.
+const maxCompletionsShown = 12
+
+// completeCommand completes the word under the cursor on the command line,
+// a command name or one of its arguments, and lists the matches when there
+// is more than one.
+func (g *Game) completeCommand() {
+	words, _ := g.commandLine.Words()
+
+	var candidates []string
+	if len(words) == 0 {
+		candidates = commandNames(g)
+	} else if command, ok := findGameCommand(words[0]); ok && command.complete != nil && len(words) <= command.maxArgs {
+		candidates = command.complete(g)
+	}
+
+	matches := g.commandLine.Complete(candidates)
+	if len(matches) > maxCompletionsShown {
+		matches = append(matches[:maxCompletionsShown], "...")
+	}
+	if len(matches) > 1 {
+		g.message = strings.Join(matches, "  ")
+	}
+}
+
+func commandNames(g *Game) []string {
+	var names []string
+	for _, command := range gameCommands {
+		names = append(names, command.name)
+		if command.alias != "" {
+			names = append(names, command.alias)
+		}
+	}
+	return names
+}
+
+// squares lists the squares of the cells for which keep returns true, by
+// file and then rank.
+func squares(g *Game, keep func(row, col int) bool) []string {
+	var names []string
+	for col := 0; col < g.queens.Size(); col++ {
+		for row := g.queens.Size() - 1; row >= 0; row-- {
+			if keep(row, col) {
+				names = append(names, g.queens.Square(row, col))
+			}
+		}
+	}
+	return names
+}
+
+func allSquares(g *Game) []string {
+	return squares(g, func(row, col int) bool { return true })
+}
+
+func queenSquares(g *Game) []string {
+	return squares(g, g.queens.HasQueen)
+}
+
+// freeSquares lists the empty cells, leaving out attacked ones unless in
+// hard mode.
+func freeSquares(g *Game) []string {
+	return squares(g, func(row, col int) bool {
+		return !g.queens.HasQueen(row, col) && (g.hard || !g.queens.IsUnderAttack(row, col))
+	})
+}
+
+func boardSizes(g *Game) []string {
+	var sizes []string
+	for size := MinBoardSize; size <= MaxBoardSize; size++ {
+		sizes = append(sizes, strconv.Itoa(size))
+	}
+	return sizes
+}
+
+func symbols(g *Game) []string {
+	return []string{"black", "white", "ascii"}
+}
+
+func labelStyles(g *Game) []string {
+	return []string{"none", "chess", "numeric"}
+}
+
+func saveNames(g *Game) []string {
+	return ListSaves(g.player)
+}
+
+// parseCommandLine splits a command line like ":place e4" into the command
+// and its arguments.
+func parseCommandLine(line string) (gameCommand, []string, error) {
+	line = strings.TrimSpace(strings.TrimPrefix(line, ":"))
+	name, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
+
+	command, ok := findGameCommand(name)
+	if !ok {
+		return gameCommand{}, nil, fmt.Errorf("%w %q, try :help", ErrUnknownCommand, name)
+	}
+
+	args := strings.Fields(rest)
+	if command.freeForm && rest != "" {
+		args = []string{rest}
+	}
+	if len(args) < command.minArgs || len(args) > command.maxArgs {
+		return gameCommand{}, nil, fmt.Errorf("%w: :%s", ErrUsage, command.usage)
+	}
+	return command, args, nil
+}
+
+// execute runs a command line and reports whether the game should exit.
+// Errors are shown on the command line.
+func (g *Game) execute(line string) bool {
+	if strings.TrimSpace(strings.TrimPrefix(line, ":")) == "" {
+		return false
+	}
+
+	command, args, err := parseCommandLine(line)
+	if err == nil {
+		err = command.run(g, args)
+	}
+	if errors.Is(err, errQuit) {
+		return true
+	}
+	if err != nil {
+		g.commandError = fmt.Sprintf("%s: %v", line, err)
+	}
+	return false
+}
+
+// errQuit is returned by :q to stop the game.
+var errQuit = errors.New("quit")
+
+func cmdQuit(g *Game, args []string) error {
+	return errQuit
+}
+
+func cmdPlace(g *Game, args []string) error {
+	pos, err := parseCell(args[0], g.queens.Size())
+	if err != nil {
+		return err
+	}
+	if g.queens.Count() >= g.queens.Size() {
+		return fmt.Errorf("all %d queens are on the board", g.queens.Size())
+	}
+
+	if g.hard {
+		err = g.queens.PlaceQueenUnchecked(pos.Row, pos.Col)
+	} else {
+		err = g.queens.PlaceQueen(pos.Row, pos.Col)
+	}
+	if err != nil {
+		return err
+	}
+
+	g.cursorRow, g.cursorCol = pos.Row, pos.Col
+	g.boardChanged()
+	return nil
+}
+
+func cmdRemove(g *Game, args []string) error {
+	pos, err := parseCell(args[0], g.queens.Size())
+	if err != nil {
+		return err
+	}
+	if err := g.queens.RemoveQueen(pos.Row, pos.Col); err != nil {
+		return err
+	}
+
+	g.cursorRow, g.cursorCol = pos.Row, pos.Col
+	g.boardChanged()
+	return nil
+}
+
+func cmdGoto(g *Game, args []string) error {
+	pos, err := parseCell(args[0], g.queens.Size())
+	if err != nil {
+		return err
+	}
+	g.cursorRow, g.cursorCol = pos.Row, pos.Col
+	return nil
+}
+
+func cmdReset(g *Game, args []string) error {
+	g.queens.Reset()
+	g.boardChanged()
+	g.cursorRow, g.cursorCol = 0, 0
+	return nil
+}
+
+// cmdSize keeps the game on the current board size and continues the last
+// one played on the new size.
+func cmdSize(g *Game, args []string) error {
+	size, err := strconv.Atoi(args[0])
+	if err != nil || size < MinBoardSize || size > MaxBoardSize {
+		return fmt.Errorf("size must be between %d and %d", MinBoardSize, MaxBoardSize)
+	}
+	if size == g.queens.Size() {
+		return nil
+	}
+
+	g.Autosave()
+	if err := g.resize(size); err != nil {
+		return err
+	}
+	g.RestoreAutosave()
+	if g.message == "" {
+		g.message = fmt.Sprintf("Playing on a %dx%d board", size, size)
+	}
+	return nil
+}
+
+func cmdSymbol(g *Game, args []string) error {
+	var symbol QueenSymbol
+	if err := symbol.UnmarshalText([]byte(args[0])); err != nil {
+		return err
+	}
+	g.queens.SetSymbol(symbol)
+	return nil
+}
+
+func cmdLabels(g *Game, args []string) error {
+	var style LabelStyle
+	if err := style.UnmarshalText([]byte(args[0])); err != nil {
+		return err
+	}
+	g.chooseLabels(style)
+	return nil
+}
+
+func cmdHint(g *Game, args []string) error {
+	if g.hard {
+		return ErrHardMode
+	}
+	g.showHint()
+	return nil
+}
+
+// cmdSolve fills in the board with the first solution the placement can
+// be completed to. The solution is not recorded as found.
+func cmdSolve(g *Game, args []string) error {
+	if g.hard {
+		return ErrHardMode
+	}
+
+	solver, err := NewSolverFrom(&g.queens)
+	if err != nil {
+		return err
+	}
+	solutions := solver.Solutions(1)
+	if len(solutions) == 0 {
+		return ErrUnsolvable
+	}
+
+	g.queens.Load(solutions[0])
+	g.solverBoard = solutions[0]
+	g.boardChanged()
+	return nil
+}
+
+func cmdStats(g *Game, args []string) error {
+	total := 0
+	for _, orbit := range g.orbits {
+		total += orbit
+	}
+
+	stats := fmt.Sprintf("%dx%d: ", g.queens.Size(), g.queens.Size())
+	if len(g.fundamentals) > 0 {
+		stats += fmt.Sprintf("%d/%d fundamental, %d/%d distinct solutions", countSolved(g.solved()), len(g.fundamentals), len(g.found()), total)
+	} else {
+		stats += fmt.Sprintf("%d distinct solutions", len(g.found()))
+	}
+	stats += fmt.Sprintf(", %d hints used", g.config.Players[g.player].Hints)
+
+	g.message = stats
+	return nil
+}
+
+// cmdLoad replaces the board with one typed in any of the interchange
+// formats. Outside hard mode the loaded queens must not attack each other.
+// Like a board the solver filled in, it doesn't count as found until the
+// player places a queen of their own.
+func cmdLoad(g *Game, args []string) error {
+	size := g.queens.Size()
+	loaded, err := DetectBoardFormat(args[0]).Decode(args[0], size)
+	if err != nil {
+		return err
+	}
+
+	if loaded.Size() != size {
+		return fmt.Errorf("a %dx%d board does not fit this %dx%d one", loaded.Size(), loaded.Size(), size, size)
+	}
+	if loaded.Count() > size {
+		return fmt.Errorf("more than %d queens", size)
+	}
+	if !g.hard {
+		if _, err := NewSolverFrom(&loaded); err != nil {
+			return err
+		}
+	}
+
+	g.queens.Load(loaded.queens)
+	g.solverBoard = slices.Clone(loaded.queens)
+	g.boardChanged()
+	if g.message == "" {
+		g.message = fmt.Sprintf("Loaded %d queens", loaded.Count())
+	}
+	return nil
+}
+
+func cmdWrite(g *Game, args []string) error {
+	if err := WriteSave(g.player, args[0], g.saved()); err != nil {
+		return err
+	}
+	g.message = fmt.Sprintf("Saved as %q", args[0])
+	return nil
+}
+
+func cmdEdit(g *Game, args []string) error {
+	save, err := ReadSave(g.player, args[0])
+	if err != nil {
+		return err
+	}
+	if err := g.restore(save); err != nil {
+		return err
+	}
+
+	g.message = fmt.Sprintf("Opened %q", args[0])
+	if g.hard {
+		g.message += " in hard mode"
+	}
+	g.Autosave()
+	return nil
+}
+
+func cmdHelp(g *Game, args []string) error {
+	if len(args) == 1 {
+		command, ok := findGameCommand(strings.TrimPrefix(args[0], ":"))
+		if !ok {
+			return fmt.Errorf("%w %q", ErrUnknownCommand, args[0])
+		}
+		g.message = fmt.Sprintf(":%s  %s", command.usage, command.help)
+		return nil
+	}
+
+	var names []string
+	for _, command := range gameCommands {
+		name := command.name
+		if command.alias != "" {
+			name = command.alias
+		}
+		names = append(names, name)
+	}
+	g.message = "Commands: " + strings.Join(names, " ") + "  (:help COMMAND for more)"
+	return nil
+}
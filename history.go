@@ -0,0 +1,154 @@
+package main
+
+import "errors"
+
+var (
+	ErrNothingToUndo = errors.New("nothing to undo")
+	ErrNothingToRedo = errors.New("nothing to redo")
+	ErrBadHistory    = errors.New("move does not fit the board")
+)
+
+type MoveKind int
+
+const (
+	MovePlace MoveKind = iota
+	MoveRemove
+	MoveReset
+	MoveLoad
+)
+
+// Move is one recorded change to the board. A reset keeps the queens it
+// cleared so that undoing it can put them back; a load keeps both the
+// queens it placed and the ones it replaced.
+type Move struct {
+	Kind     MoveKind   `json:"kind"`
+	Row      int        `json:"row,omitempty"`
+	Col      int        `json:"col,omitempty"`
+	Queens   []Position `json:"queens,omitempty"`
+	Previous []Position `json:"previous,omitempty"`
+}
+
+// History holds the moves that can be undone, oldest first, and the undone
+// moves that can be redone, most recently undone last.
+type History struct {
+	Done   []Move `json:"done"`
+	Undone []Move `json:"undone"`
+}
+
+func (q *Queens) record(move Move) {
+	q.history.Done = append(q.history.Done, move)
+	q.history.Undone = nil
+}
+
+func (q *Queens) Undo() error {
+	if len(q.history.Done) == 0 {
+		return ErrNothingToUndo
+	}
+
+	move := q.history.Done[len(q.history.Done)-1]
+	if !q.revert(move) {
+		return ErrBadHistory
+	}
+
+	q.history.Done = q.history.Done[:len(q.history.Done)-1]
+	q.history.Undone = append(q.history.Undone, move)
+	return nil
+}
+
+func (q *Queens) Redo() error {
+	if len(q.history.Undone) == 0 {
+		return ErrNothingToRedo
+	}
+
+	move := q.history.Undone[len(q.history.Undone)-1]
+	if !q.apply(move) {
+		return ErrBadHistory
+	}
+
+	q.history.Undone = q.history.Undone[:len(q.history.Undone)-1]
+	q.history.Done = append(q.history.Done, move)
+	return nil
+}
+
+// apply makes a move again. It reports false, leaving the board as it was,
+// if the board isn't the one the move was made on.
+func (q *Queens) apply(move Move) bool {
+	switch move.Kind {
+	case MovePlace:
+		return q.add(move.Row, move.Col)
+	case MoveRemove:
+		return q.remove(move.Row, move.Col)
+	case MoveReset:
+		if !samePositions(q.queens, move.Queens) {
+			return false
+		}
+		q.clear()
+	case MoveLoad:
+		if !samePositions(q.queens, move.Previous) {
+			return false
+		}
+		q.replace(move.Queens)
+	default:
+		return false
+	}
+	return true
+}
+
+// revert takes a move back. It reports false, leaving the board as it was,
+// if the board isn't the one the move left.
+func (q *Queens) revert(move Move) bool {
+	switch move.Kind {
+	case MovePlace:
+		return q.remove(move.Row, move.Col)
+	case MoveRemove:
+		return q.add(move.Row, move.Col)
+	case MoveReset:
+		if len(q.queens) > 0 {
+			return false
+		}
+		q.replace(move.Queens)
+	case MoveLoad:
+		if !samePositions(q.queens, move.Queens) {
+			return false
+		}
+		q.replace(move.Previous)
+	default:
+		return false
+	}
+	return true
+}
+
+// samePositions reports whether two lists hold the same positions, in any
+// order, ignoring repeats.
+func samePositions(a, b []Position) bool {
+	set := make(map[Position]bool, len(a))
+	for _, pos := range a {
+		set[pos] = true
+	}
+	other := make(map[Position]bool, len(b))
+	for _, pos := range b {
+		if !set[pos] {
+			return false
+		}
+		other[pos] = true
+	}
+	return len(other) == len(set)
+}
+
+func (q *Queens) CanUndo() bool {
+	return len(q.history.Done) > 0
+}
+
+func (q *Queens) CanRedo() bool {
+	return len(q.history.Undone) > 0
+}
+
+// History returns the recorded moves.
+func (q *Queens) History() History {
+	return q.history
+}
+
+// SetHistory replaces the recorded moves, as when restoring a saved game.
+func (q *Queens) SetHistory(history History) {
+	q.history = history
+}
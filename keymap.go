@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrUnknownKey    = errors.New("unknown key")
+	ErrUnknownAction = errors.New("unknown action")
+	ErrUnknownPreset = errors.New("unknown key preset")
+	ErrKeyConflict   = errors.New("conflicting bindings")
+	ErrUnreachable   = errors.New("unreachable")
+)
+
+// ctrlAliases are the Ctrl keys whose bytes the terminal sends for other
+// keys, so that they are always decoded as those keys.
+var ctrlAliases = map[rune]string{
+	'h': "backspace",
+	'i': "tab",
+	'j': "enter",
+	'm': "enter",
+}
+
+// codeNames names the codes that keys can be bound to, as written in
+// keys.json. "none" unbinds a key.
+var codeNames = map[Code]string{
+	CodeExit:        "exit",
+	CodeReset:       "reset",
+	CodeUndo:        "undo",
+	CodeRedo:        "redo",
+	CodeLeft:        "left",
+	CodeRight:       "right",
+	CodeUp:          "up",
+	CodeDown:        "down",
+	CodeHome:        "first-column",
+	CodeEnd:         "last-column",
+	CodePageUp:      "first-row",
+	CodePageDown:    "last-row",
+	CodePlace:       "place",
+	CodeHelp:        "help",
+	CodeHint:        "hint",
+	CodeVariants:    "variants",
+	CodeSymbolBlack: "symbol-black",
+	CodeSymbolWhite: "symbol-white",
+	CodeSymbolAscii: "symbol-ascii",
+	CodeCommand:     "command",
+	CodeNone:        "none",
+}
+
+func (c Code) MarshalText() ([]byte, error) {
+	name, ok := codeNames[c]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownAction, int(c))
+	}
+	return []byte(name), nil
+}
+
+func (c *Code) UnmarshalText(text []byte) error {
+	for code, name := range codeNames {
+		if name == string(text) {
+			*c = code
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q", ErrUnknownAction, text)
+}
+
+var keyNames = map[Key]string{
+	KeyEnter:     "enter",
+	KeyTab:       "tab",
+	KeyBackspace: "backspace",
+	KeyEsc:       "esc",
+	KeyUp:        "up",
+	KeyDown:      "down",
+	KeyRight:     "right",
+	KeyLeft:      "left",
+	KeyHome:      "home",
+	KeyEnd:       "end",
+	KeyPageUp:    "pgup",
+	KeyPageDown:  "pgdn",
+	KeyInsert:    "insert",
+	KeyDelete:    "delete",
+}
+
+var modifierNames = []struct {
+	mod  Modifier
+	name string
+}{
+	{ModCtrl, "ctrl-"},
+	{ModAlt, "alt-"},
+	{ModShift, "shift-"},
+}
+
+// String names the key as keys.json does: "h", "H", "space", "ctrl-r",
+// "shift-up" and so on.
+func (e KeyEvent) String() string {
+	var name strings.Builder
+	for _, modifier := range modifierNames {
+		if e.Mod&modifier.mod != 0 {
+			name.WriteString(modifier.name)
+		}
+	}
+
+	switch {
+	case e.Key == KeyRune && e.Rune == ' ':
+		name.WriteString("space")
+	case e.Key == KeyRune:
+		name.WriteRune(e.Rune)
+	case keyNames[e.Key] != "":
+		name.WriteString(keyNames[e.Key])
+	default:
+		name.WriteString("unknown")
+	}
+	return name.String()
+}
+
+// ParseKey reads a key name as written by KeyEvent.String. Modifiers and
+// named keys are case-insensitive, and so is the letter of a Ctrl key.
+// Shift only goes with named keys: a shifted character is written as the
+// character itself, as in "H". Ctrl keys that arrive as other keys, like
+// Ctrl-M as Enter, are ErrUnreachable.
+func ParseKey(name string) (KeyEvent, error) {
+	var event KeyEvent
+	rest := name
+	for {
+		found := false
+		for _, modifier := range modifierNames {
+			if len(rest) > len(modifier.name) && strings.EqualFold(rest[:len(modifier.name)], modifier.name) {
+				event.Mod |= modifier.mod
+				rest = rest[len(modifier.name):]
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+	}
+
+	if utf8.RuneCountInString(rest) == 1 {
+		if event.Mod&ModShift != 0 {
+			return KeyEvent{}, fmt.Errorf("%w %q, write the shifted character instead", ErrUnknownKey, name)
+		}
+		event.Key = KeyRune
+		event.Rune, _ = utf8.DecodeRuneInString(rest)
+		if event.Mod&ModCtrl != 0 {
+			event.Rune = []rune(strings.ToLower(rest))[0]
+			if alias, ok := ctrlAliases[event.Rune]; ok {
+				return KeyEvent{}, fmt.Errorf("%w: %q arrives as %s", ErrUnreachable, name, alias)
+			}
+		}
+		return event, nil
+	}
+
+	if strings.EqualFold(rest, "space") {
+		if event.Mod&ModShift != 0 {
+			return KeyEvent{}, fmt.Errorf("%w %q", ErrUnknownKey, name)
+		}
+		event.Key = KeyRune
+		event.Rune = ' '
+		return event, nil
+	}
+	for key, keyName := range keyNames {
+		if strings.EqualFold(rest, keyName) {
+			event.Key = key
+			return event, nil
+		}
+	}
+	return KeyEvent{}, fmt.Errorf("%w %q", ErrUnknownKey, name)
+}
+
+type keyBinding struct {
+	key  string
+	code Code
+}
+
+// keyPresets are the built-in keymaps. keys.json changes one of them.
+var keyPresets = map[string][]keyBinding{
+	"default": {
+		{"esc", CodeExit}, {":", CodeCommand},
+		{"r", CodeReset}, {"R", CodeReset},
+		{"u", CodeUndo}, {"U", CodeUndo}, {"ctrl-r", CodeRedo},
+		{"h", CodeHelp}, {"H", CodeHelp}, {"?", CodeHint},
+		{"v", CodeVariants}, {"V", CodeVariants},
+		{"b", CodeSymbolBlack}, {"B", CodeSymbolBlack},
+		{"w", CodeSymbolWhite}, {"W", CodeSymbolWhite},
+		{"q", CodeSymbolAscii}, {"Q", CodeSymbolAscii},
+		{"space", CodePlace}, {"enter", CodePlace},
+		{"up", CodeUp}, {"down", CodeDown}, {"left", CodeLeft}, {"right", CodeRight},
+		{"home", CodeHome}, {"end", CodeEnd}, {"pgup", CodePageUp}, {"pgdn", CodePageDown},
+	},
+	"vim": {
+		{"q", CodeExit}, {"esc", CodeExit}, {":", CodeCommand},
+		{"r", CodeReset}, {"u", CodeUndo}, {"ctrl-r", CodeRedo},
+		{"a", CodeHelp}, {"?", CodeHint}, {"v", CodeVariants},
+		{"b", CodeSymbolBlack}, {"w", CodeSymbolWhite}, {"Q", CodeSymbolAscii},
+		{"x", CodePlace}, {"space", CodePlace}, {"enter", CodePlace},
+		{"k", CodeUp}, {"j", CodeDown}, {"h", CodeLeft}, {"l", CodeRight},
+		{"up", CodeUp}, {"down", CodeDown}, {"left", CodeLeft}, {"right", CodeRight},
+		{"0", CodeHome}, {"^", CodeHome}, {"$", CodeEnd}, {"g", CodePageUp}, {"G", CodePageDown},
+		{"home", CodeHome}, {"end", CodeEnd}, {"pgup", CodePageUp}, {"pgdn", CodePageDown},
+	},
+	"wasd": {
+		{"q", CodeExit}, {"esc", CodeExit}, {":", CodeCommand},
+		{"r", CodeReset}, {"z", CodeUndo}, {"u", CodeUndo}, {"ctrl-r", CodeRedo},
+		{"h", CodeHelp}, {"?", CodeHint}, {"v", CodeVariants},
+		{"1", CodeSymbolBlack}, {"2", CodeSymbolWhite}, {"3", CodeSymbolAscii},
+		{"e", CodePlace}, {"space", CodePlace}, {"enter", CodePlace},
+		{"w", CodeUp}, {"s", CodeDown}, {"a", CodeLeft}, {"d", CodeRight},
+		{"up", CodeUp}, {"down", CodeDown}, {"left", CodeLeft}, {"right", CodeRight},
+		{"home", CodeHome}, {"end", CodeEnd}, {"pgup", CodePageUp}, {"pgdn", CodePageDown},
+	},
+}
+
+// KeyPresetNames returns the names of the built-in keymaps.
+func KeyPresetNames() []string {
+	var names []string
+	for name := range keyPresets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Keymap maps the keys pressed outside command mode to what they do.
+type Keymap struct {
+	bindings map[KeyEvent]Code
+}
+
+// Lookup returns the code bound to a key, or CodeNone. A special key held
+// with modifiers that have no binding of their own acts as the plain key.
+func (k Keymap) Lookup(event KeyEvent) Code {
+	if code, ok := k.bindings[event]; ok {
+		return code
+	}
+	if event.Key != KeyRune && event.Mod != 0 {
+		return k.Lookup(KeyEvent{Key: event.Key})
+	}
+	return CodeNone
+}
+
+// Keys returns the names of the keys bound to a code: characters first,
+// lower case before upper case, then Ctrl and Alt keys, then named keys.
+func (k Keymap) Keys(code Code) []string {
+	var keys []KeyEvent
+	for event, bound := range k.bindings {
+		if bound == code {
+			keys = append(keys, event)
+		}
+	}
+
+	rank := func(e KeyEvent) int {
+		switch {
+		case e.Key == KeyRune && e.Rune != ' ' && e.Mod == 0:
+			return 0
+		case e.Key == KeyRune && e.Rune != ' ':
+			return 1
+		default:
+			return 2
+		}
+	}
+	slices.SortFunc(keys, func(a, b KeyEvent) int {
+		if rank(a) != rank(b) {
+			return rank(a) - rank(b)
+		}
+		if a.Key == KeyRune && b.Key == KeyRune && a.Rune != b.Rune {
+			la, lb := []rune(strings.ToLower(string(a.Rune)))[0], []rune(strings.ToLower(string(b.Rune)))[0]
+			if la != lb {
+				return int(la) - int(lb)
+			}
+			return int(b.Rune) - int(a.Rune)
+		}
+		if a.Key != b.Key {
+			return int(a.Key) - int(b.Key)
+		}
+		return int(a.Mod) - int(b.Mod)
+	})
+
+	names := make([]string, len(keys))
+	for i, event := range keys {
+		names[i] = event.String()
+	}
+	return names
+}
+
+// KeyFile is the keys.json file: a preset, and bindings that change it,
+// from key names to action names, in the order written, as in
+// {"preset": "vim", "keys": {"p": "place", "x": "none"}}.
+type KeyFile struct {
+	Preset   string
+	Bindings []KeyFileBinding
+}
+
+type KeyFileBinding struct {
+	Key    string
+	Action string
+}
+
+func (f *KeyFile) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Preset string          `json:"preset"`
+		Keys   json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	f.Preset = raw.Preset
+	f.Bindings = nil
+	if len(raw.Keys) == 0 {
+		return nil
+	}
+
+	// The bindings are read token by token to keep their order and any
+	// key written twice, which a map would silently drop.
+	decoder := json.NewDecoder(strings.NewReader(string(raw.Keys)))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return fmt.Errorf("keys must be an object of key names to actions")
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		var action string
+		if err := decoder.Decode(&action); err != nil {
+			return err
+		}
+		f.Bindings = append(f.Bindings, KeyFileBinding{Key: token.(string), Action: action})
+	}
+	return nil
+}
+
+func GetKeysPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".queens/keys.json"
+	}
+	return filepath.Join(home, ".queens", "keys.json")
+}
+
+// LoadKeyFile reads keys.json, which need not exist.
+func LoadKeyFile() (KeyFile, error) {
+	file, err := os.Open(GetKeysPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return KeyFile{}, nil
+		}
+		return KeyFile{}, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return KeyFile{}, err
+	}
+
+	var keyFile KeyFile
+	if err := json.Unmarshal(data, &keyFile); err != nil {
+		return KeyFile{}, fmt.Errorf("%s: %w", GetKeysPath(), err)
+	}
+	return keyFile, nil
+}
+
+// NewKeymap builds the keymap from a preset, the one named in the key file
+// if preset is empty, and the key file's bindings on top of it. It returns
+// every problem found: unknown names, a key bound twice in the file to
+// different actions, and no key left to enter command mode, which would
+// leave no way to run :q.
+func NewKeymap(preset string, keyFile KeyFile) (Keymap, []error) {
+	if preset == "" {
+		preset = keyFile.Preset
+	}
+	if preset == "" {
+		preset = "default"
+	}
+
+	bindings, ok := keyPresets[preset]
+	if !ok {
+		return Keymap{}, []error{fmt.Errorf("%w %q, expected one of %s", ErrUnknownPreset, preset, strings.Join(KeyPresetNames(), ", "))}
+	}
+
+	keymap := Keymap{bindings: make(map[KeyEvent]Code)}
+	for _, binding := range bindings {
+		event, _ := ParseKey(binding.key)
+		keymap.bindings[event] = binding.code
+	}
+
+	var errs []error
+	fromFile := make(map[KeyEvent]KeyFileBinding)
+	for _, binding := range keyFile.Bindings {
+		event, err := ParseKey(binding.Key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		var code Code
+		if err := code.UnmarshalText([]byte(binding.Action)); err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", binding.Key, err))
+			continue
+		}
+
+		if earlier, ok := fromFile[event]; ok && earlier.Action != binding.Action {
+			errs = append(errs, fmt.Errorf("%w: %q is bound to %s and %q to %s, but both are %s",
+				ErrKeyConflict, earlier.Key, earlier.Action, binding.Key, binding.Action, event))
+			continue
+		}
+		fromFile[event] = binding
+
+		if code == CodeNone {
+			delete(keymap.bindings, event)
+		} else {
+			keymap.bindings[event] = code
+		}
+	}
+
+	if len(keymap.Keys(CodeCommand)) == 0 {
+		errs = append(errs, fmt.Errorf("%w: no key enters command mode", ErrUnreachable))
+	}
+	return keymap, errs
+}
+
+// LoadKeymap builds the keymap from keys.json and the given preset, and
+// joins all the problems found into one error.
+func LoadKeymap(preset string) (Keymap, error) {
+	keyFile, err := LoadKeyFile()
+	if err != nil {
+		return Keymap{}, err
+	}
+
+	keymap, errs := NewKeymap(preset, keyFile)
+	if len(errs) > 0 {
+		return Keymap{}, fmt.Errorf("%s: %w", GetKeysPath(), errors.Join(errs...))
+	}
+	return keymap, nil
+}
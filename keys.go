@@ -0,0 +1,313 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type Key int
+
+const (
+	KeyUnknown Key = iota
+	KeyRune
+	KeyEnter
+	KeyTab
+	KeyBackspace
+	KeyEsc
+	KeyUp
+	KeyDown
+	KeyRight
+	KeyLeft
+	KeyHome
+	KeyEnd
+	KeyPageUp
+	KeyPageDown
+	KeyInsert
+	KeyDelete
+	KeyMouse
+)
+
+// Modifier is a set of the modifier keys held with a key.
+type Modifier int
+
+const (
+	ModShift Modifier = 1 << iota
+	ModAlt
+	ModCtrl
+)
+
+type MouseButton int
+
+const (
+	MouseLeft MouseButton = iota
+	MouseMiddle
+	MouseRight
+	MouseNone
+	MouseWheelUp
+	MouseWheelDown
+)
+
+// Mouse is a mouse event reported in SGR mode: a button pressed or released,
+// or the mouse moved, at a 1-based screen column X and row Y.
+type Mouse struct {
+	X, Y    int
+	Button  MouseButton
+	Motion  bool
+	Release bool
+}
+
+// KeyEvent is one decoded keypress. For KeyRune, Rune holds the character;
+// control characters are given as the letter with ModCtrl, so Ctrl-R is
+// {KeyRune, 'r', ModCtrl}. For KeyMouse, Mouse holds the event.
+type KeyEvent struct {
+	Key   Key
+	Rune  rune
+	Mod   Modifier
+	Mouse Mouse
+}
+
+// maxSequenceLength bounds an escape sequence, so that a stray ESC [ does
+// not hold back the input after it for long.
+const maxSequenceLength = 32
+
+// KeyDecoder turns terminal input into key events. Input may be fed in any
+// pieces: a sequence split across reads is held until the rest arrives, or
+// until the caller decides no more is coming, as after a lone Esc.
+type KeyDecoder struct {
+	buf []byte
+}
+
+func (d *KeyDecoder) Feed(data []byte) {
+	d.buf = append(d.buf, data...)
+}
+
+// Pending reports whether input is held back waiting for more.
+func (d *KeyDecoder) Pending() bool {
+	return len(d.buf) > 0
+}
+
+// Next decodes the next key event. With flush false it reports false when
+// the input so far could be the start of a longer sequence; with flush true
+// it decodes what there is, so a lone ESC becomes KeyEsc.
+func (d *KeyDecoder) Next(flush bool) (KeyEvent, bool) {
+	if len(d.buf) == 0 {
+		return KeyEvent{}, false
+	}
+
+	event, n := decodeKey(d.buf, flush)
+	if n == 0 {
+		return KeyEvent{}, false
+	}
+	d.buf = d.buf[n:]
+	return event, true
+}
+
+// decodeKey decodes the key at the start of buf and returns it with the
+// number of bytes it took, or 0 bytes if buf ends before the key does.
+func decodeKey(buf []byte, flush bool) (KeyEvent, int) {
+	b := buf[0]
+	switch {
+	case b == 0x1b:
+		return decodeEscape(buf, flush)
+	case b == '\r' || b == '\n':
+		return KeyEvent{Key: KeyEnter}, 1
+	case b == '\t':
+		return KeyEvent{Key: KeyTab}, 1
+	case b == 0x7f || b == 0x08:
+		return KeyEvent{Key: KeyBackspace}, 1
+	case b >= 0x01 && b <= 0x1a:
+		return KeyEvent{Key: KeyRune, Rune: rune('a' + b - 1), Mod: ModCtrl}, 1
+	case b < 0x20:
+		return KeyEvent{Key: KeyUnknown}, 1
+	}
+
+	if !utf8.FullRune(buf) {
+		if flush {
+			return KeyEvent{Key: KeyUnknown}, len(buf)
+		}
+		return KeyEvent{}, 0
+	}
+	r, size := utf8.DecodeRune(buf)
+	if r == utf8.RuneError {
+		return KeyEvent{Key: KeyUnknown}, size
+	}
+	return KeyEvent{Key: KeyRune, Rune: r}, size
+}
+
+// decodeEscape decodes a key starting with ESC: Esc itself, a CSI or SS3
+// sequence, or a key pressed with Alt, which terminals send as ESC and the
+// key.
+func decodeEscape(buf []byte, flush bool) (KeyEvent, int) {
+	if len(buf) == 1 {
+		if flush {
+			return KeyEvent{Key: KeyEsc}, 1
+		}
+		return KeyEvent{}, 0
+	}
+
+	switch buf[1] {
+	case 0x1b:
+		// Esc pressed just before another key that starts with ESC.
+		return KeyEvent{Key: KeyEsc}, 1
+	case '[':
+		if event, n := decodeCSI(buf); n > 0 || !flush {
+			return event, n
+		}
+	case 'O':
+		if len(buf) > 2 {
+			return decodeSS3(buf[2]), 3
+		}
+		if !flush {
+			return KeyEvent{}, 0
+		}
+	}
+
+	event, n := decodeKey(buf[1:], flush)
+	if n == 0 {
+		return KeyEvent{}, 0
+	}
+	event.Mod |= ModAlt
+	return event, n + 1
+}
+
+// decodeCSI decodes ESC [ followed by parameter bytes, intermediate bytes
+// and a final byte. It returns 0 bytes while the final byte is missing.
+func decodeCSI(buf []byte) (KeyEvent, int) {
+	end := min(len(buf), maxSequenceLength)
+	i := 2
+	for i < end && buf[i] >= 0x30 && buf[i] <= 0x3f {
+		i++
+	}
+	for i < end && buf[i] >= 0x20 && buf[i] <= 0x2f {
+		i++
+	}
+
+	if i == maxSequenceLength {
+		return KeyEvent{Key: KeyUnknown}, i
+	}
+	if i == len(buf) {
+		return KeyEvent{}, 0
+	}
+	if buf[i] < 0x40 || buf[i] > 0x7e {
+		// Not a valid sequence: drop what was read of it.
+		return KeyEvent{Key: KeyUnknown}, i
+	}
+
+	params := strings.Split(string(buf[2:i]), ";")
+	return csiKey(params, buf[i]), i + 1
+}
+
+// csiKey maps the parameters and final byte of a CSI sequence to a key.
+// The second parameter, when there is one, is 1 plus the modifier bits.
+func csiKey(params []string, final byte) KeyEvent {
+	if strings.HasPrefix(params[0], "<") && (final == 'M' || final == 'm') {
+		return sgrMouse(params, final == 'm')
+	}
+
+	var event KeyEvent
+	switch final {
+	case 'A':
+		event.Key = KeyUp
+	case 'B':
+		event.Key = KeyDown
+	case 'C':
+		event.Key = KeyRight
+	case 'D':
+		event.Key = KeyLeft
+	case 'H':
+		event.Key = KeyHome
+	case 'F':
+		event.Key = KeyEnd
+	case 'Z':
+		return KeyEvent{Key: KeyTab, Mod: ModShift}
+	case '~':
+		switch params[0] {
+		case "1", "7":
+			event.Key = KeyHome
+		case "2":
+			event.Key = KeyInsert
+		case "3":
+			event.Key = KeyDelete
+		case "4", "8":
+			event.Key = KeyEnd
+		case "5":
+			event.Key = KeyPageUp
+		case "6":
+			event.Key = KeyPageDown
+		}
+	}
+
+	if event.Key != KeyUnknown && len(params) > 1 {
+		if mod, err := strconv.Atoi(params[1]); err == nil && mod > 1 {
+			event.Mod = Modifier(mod-1) & (ModShift | ModAlt | ModCtrl)
+		}
+	}
+	return event
+}
+
+// decodeSS3 maps the byte after ESC O, which some terminals send for the
+// arrows and Home/End in application mode.
+func decodeSS3(final byte) KeyEvent {
+	switch final {
+	case 'A':
+		return KeyEvent{Key: KeyUp}
+	case 'B':
+		return KeyEvent{Key: KeyDown}
+	case 'C':
+		return KeyEvent{Key: KeyRight}
+	case 'D':
+		return KeyEvent{Key: KeyLeft}
+	case 'H':
+		return KeyEvent{Key: KeyHome}
+	case 'F':
+		return KeyEvent{Key: KeyEnd}
+	case 'M':
+		return KeyEvent{Key: KeyEnter}
+	default:
+		return KeyEvent{Key: KeyUnknown}
+	}
+}
+
+// sgrMouse decodes the parameters of an SGR mouse report, ESC [ < b ; x ; y
+// followed by M for a press or motion and m for a release. The low bits of
+// b give the button, and the higher ones the modifiers, motion and wheel.
+func sgrMouse(params []string, release bool) KeyEvent {
+	if len(params) != 3 {
+		return KeyEvent{Key: KeyUnknown}
+	}
+
+	var values [3]int
+	for i, param := range params {
+		value, err := strconv.Atoi(strings.TrimPrefix(param, "<"))
+		if err != nil || value < 0 {
+			return KeyEvent{Key: KeyUnknown}
+		}
+		values[i] = value
+	}
+
+	b := values[0]
+	event := KeyEvent{
+		Key: KeyMouse,
+		Mouse: Mouse{
+			X:       values[1],
+			Y:       values[2],
+			Button:  MouseButton(b & 3),
+			Motion:  b&32 != 0,
+			Release: release,
+		},
+	}
+	if b&64 != 0 {
+		event.Mouse.Button = MouseWheelUp + MouseButton(b&1)
+	}
+	if b&4 != 0 {
+		event.Mod |= ModShift
+	}
+	if b&8 != 0 {
+		event.Mod |= ModAlt
+	}
+	if b&16 != 0 {
+		event.Mod |= ModCtrl
+	}
+	return event
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// placement is where a panel goes when the board has panels beside it.
+// Stacked in one column, panels simply go one below the other in the
+// order they were drawn.
+type placement int
+
+const (
+	placeTop placement = iota
+	placeBoard
+	placeSide
+	placeBottom
+)
+
+// panel is a block of lines drawn together. When the terminal is too short
+// for all of them, the panels with the highest hideOrder are hidden first.
+// A boxed panel is drawn in a frame, which wrapping its lines would break.
+type panel struct {
+	lines     []string
+	place     placement
+	hideOrder int
+	boxed     bool
+}
+
+const (
+	showAlways = iota
+	hideGrid
+	hideTitle
+	hidePrizes
+	hideControls
+)
+
+const (
+	// columnGap is the space between the board and the panels beside it.
+	columnGap = 4
+	// minSideWidth is the narrowest the column beside the board can be,
+	// enough for the status line and the controls.
+	minSideWidth = 32
+)
+
+// screenLayout is the lines of a screen, with the 1-based column and row
+// of the board's top-left corner.
+type screenLayout struct {
+	lines  []string
+	boardX int
+	boardY int
+}
+
+// layoutPanels lays panels out on a screen of width columns and height
+// rows, where a height of 0 is unlimited. On a terminal wide enough, the
+// panels placed beside the board go in a column on its right, with their
+// lines wrapped to fit; otherwise, or if a boxed panel doesn't fit beside
+// it, all the panels are stacked in one column. Either way, panels are
+// hidden until the rest fit in the height.
+func layoutPanels(panels []panel, width, height int) screenLayout {
+	boardWidth := -1
+	sideWidth := 0
+	boxWidth := 0
+	for _, panel := range panels {
+		for _, line := range panel.lines {
+			switch {
+			case panel.place == placeBoard:
+				boardWidth = max(boardWidth, getVisibleLength(line))
+			case panel.place == placeSide && panel.boxed:
+				boxWidth = max(boxWidth, getVisibleLength(line))
+			case panel.place == placeSide:
+				sideWidth = max(sideWidth, getVisibleLength(line))
+			}
+		}
+	}
+
+	available := width - boardWidth - columnGap
+	if boardWidth < 0 || max(sideWidth, boxWidth) == 0 || available < max(minSideWidth, boxWidth) {
+		var layout screenLayout
+		layout.stack(fitPanels(panels, height, stackHeight), width)
+		return layout
+	}
+
+	sideWidth = max(min(sideWidth, available), boxWidth)
+	panels = slices.Clone(panels)
+	for i, panel := range panels {
+		if panel.place == placeSide && !panel.boxed {
+			var lines []string
+			for _, line := range panel.lines {
+				lines = append(lines, wrapLine(line, sideWidth)...)
+			}
+			panels[i].lines = lines
+		}
+	}
+	return layoutSideBySide(fitPanels(panels, height, sideBySideHeight), width, boardWidth, sideWidth)
+}
+
+// layoutSideBySide puts the board and the column beside it, centred
+// together, between the top and bottom panels.
+func layoutSideBySide(panels []panel, width, boardWidth, sideWidth int) screenLayout {
+	var layout screenLayout
+	layout.stack(placed(panels, placeTop), width)
+
+	var board []string
+	for _, panel := range placed(panels, placeBoard) {
+		board = append(board, panel.lines...)
+	}
+	var side screenLayout
+	side.stack(placed(panels, placeSide), sideWidth)
+
+	if len(layout.lines) > 0 {
+		layout.lines = append(layout.lines, "")
+	}
+	margin := max(width-boardWidth-columnGap-sideWidth, 0) / 2
+	layout.boardX, layout.boardY = margin+1, len(layout.lines)+1
+
+	for i := range max(len(board), len(side.lines)) {
+		line := strings.Repeat(" ", margin)
+		if i < len(board) {
+			line += board[i] + strings.Repeat(" ", boardWidth-getVisibleLength(board[i]))
+		} else {
+			line += strings.Repeat(" ", boardWidth)
+		}
+		if i < len(side.lines) {
+			line += strings.Repeat(" ", columnGap) + side.lines[i]
+		}
+		layout.lines = append(layout.lines, strings.TrimRight(line, " "))
+	}
+
+	layout.stack(placed(panels, placeBottom), width)
+	return layout
+}
+
+// stack adds panels below the lines already laid out, a blank line apart,
+// with each line centred in width.
+func (l *screenLayout) stack(panels []panel, width int) {
+	for _, panel := range panels {
+		if len(l.lines) > 0 {
+			l.lines = append(l.lines, "")
+		}
+		for i, line := range panel.lines {
+			leftPadding := centerPadding(line, width)
+			if panel.place == placeBoard && i == 0 {
+				l.boardX, l.boardY = leftPadding+1, len(l.lines)+1
+			}
+			l.lines = append(l.lines, strings.Repeat(" ", leftPadding)+line)
+		}
+	}
+}
+
+func placed(panels []panel, place placement) []panel {
+	var matching []panel
+	for _, panel := range panels {
+		if panel.place == place {
+			matching = append(matching, panel)
+		}
+	}
+	return matching
+}
+
+// fitPanels hides panels until the rest fit in height rows, if it isn't 0,
+// as measured by the layout's height function.
+func fitPanels(panels []panel, height int, layoutHeight func([]panel) int) []panel {
+	panels = slices.Clone(panels)
+	for height > 0 && layoutHeight(panels) > height {
+		hide := -1
+		for i, panel := range panels {
+			if panel.hideOrder != showAlways && (hide == -1 || panel.hideOrder > panels[hide].hideOrder) {
+				hide = i
+			}
+		}
+		if hide == -1 {
+			break
+		}
+		panels = slices.Delete(panels, hide, hide+1)
+	}
+	return panels
+}
+
+// stackHeight is the height of panels one below the other.
+func stackHeight(panels []panel) int {
+	height := max(len(panels)-1, 0)
+	for _, panel := range panels {
+		height += len(panel.lines)
+	}
+	return height
+}
+
+// sideBySideHeight is the height of panels laid out by layoutSideBySide.
+func sideBySideHeight(panels []panel) int {
+	middle := max(stackHeight(placed(panels, placeBoard)), stackHeight(placed(panels, placeSide)))
+	height := -1
+	for _, part := range []int{stackHeight(placed(panels, placeTop)), middle, stackHeight(placed(panels, placeBottom))} {
+		if part > 0 {
+			height += part + 1
+		}
+	}
+	return max(height, 0)
+}
+
+// wrapLine breaks a line wider than width at spaces, where it can. Colours
+// left on at the end of a line are turned off, and on again on the next.
+func wrapLine(line string, width int) []string {
+	if getVisibleLength(line) <= width {
+		return []string{line}
+	}
+
+	var lines []string
+	current := ""
+	for _, word := range strings.Split(line, " ") {
+		if current != "" && getVisibleLength(current+" "+word) > width {
+			style := openStyle(current)
+			if style != "" {
+				current += "\033[0m"
+			}
+			lines = append(lines, current)
+			current = style + word
+			continue
+		}
+		if current == "" {
+			current = word
+		} else {
+			current += " " + word
+		}
+	}
+	return append(lines, current)
+}
+
+// openStyle returns the escapes in a line since the last one to reset
+// the colours, which are still on at its end.
+func openStyle(line string) string {
+	if i := strings.LastIndex(line, "\033[0m"); i >= 0 {
+		line = line[i+len("\033[0m"):]
+	}
+
+	style := ""
+	for {
+		start := strings.Index(line, "\033[")
+		if start < 0 {
+			return style
+		}
+		end := strings.IndexByte(line[start:], 'm')
+		if end < 0 {
+			return style
+		}
+		style += line[start : start+end+1]
+		line = line[start+end+1:]
+	}
+}
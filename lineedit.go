@@ -0,0 +1,199 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxCommandHistory caps how many command lines are remembered per player.
+const maxCommandHistory = 100
+
+// LineEditor is the text typed in command mode, after the ':', with a cursor
+// and the lines entered before it, oldest first. While browsing the history
+// with Previous and Next, the line being typed is kept as a draft.
+type LineEditor struct {
+	text     []rune
+	cursor   int
+	history  []string
+	browsing int
+	draft    string
+}
+
+func NewLineEditor(history []string) LineEditor {
+	return LineEditor{history: history, browsing: len(history)}
+}
+
+// Reset clears the line and stops browsing the history.
+func (e *LineEditor) Reset() {
+	e.set("")
+	e.browsing = len(e.history)
+	e.draft = ""
+}
+
+func (e *LineEditor) set(text string) {
+	e.text = []rune(text)
+	e.cursor = len(e.text)
+}
+
+func (e *LineEditor) String() string {
+	return string(e.text)
+}
+
+// Cursor returns the position of the cursor in runes from the start.
+func (e *LineEditor) Cursor() int {
+	return e.cursor
+}
+
+func (e *LineEditor) Insert(r rune) {
+	e.text = append(e.text[:e.cursor], append([]rune{r}, e.text[e.cursor:]...)...)
+	e.cursor++
+}
+
+// Backspace deletes the rune before the cursor.
+func (e *LineEditor) Backspace() {
+	if e.cursor == 0 {
+		return
+	}
+	e.text = append(e.text[:e.cursor-1], e.text[e.cursor:]...)
+	e.cursor--
+}
+
+// Delete deletes the rune under the cursor.
+func (e *LineEditor) Delete() {
+	if e.cursor == len(e.text) {
+		return
+	}
+	e.text = append(e.text[:e.cursor], e.text[e.cursor+1:]...)
+}
+
+// DeleteWord deletes the word before the cursor and the spaces after it,
+// like Ctrl-W in a shell.
+func (e *LineEditor) DeleteWord() {
+	start := e.cursor
+	for start > 0 && unicode.IsSpace(e.text[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(e.text[start-1]) {
+		start--
+	}
+	e.text = append(e.text[:start], e.text[e.cursor:]...)
+	e.cursor = start
+}
+
+// DeleteToStart deletes everything before the cursor, like Ctrl-U.
+func (e *LineEditor) DeleteToStart() {
+	e.text = e.text[e.cursor:]
+	e.cursor = 0
+}
+
+func (e *LineEditor) Left() {
+	if e.cursor > 0 {
+		e.cursor--
+	}
+}
+
+func (e *LineEditor) Right() {
+	if e.cursor < len(e.text) {
+		e.cursor++
+	}
+}
+
+func (e *LineEditor) Home() {
+	e.cursor = 0
+}
+
+func (e *LineEditor) End() {
+	e.cursor = len(e.text)
+}
+
+// Previous replaces the line with the one entered before the line shown.
+func (e *LineEditor) Previous() {
+	if e.browsing == 0 {
+		return
+	}
+	if e.browsing == len(e.history) {
+		e.draft = e.String()
+	}
+	e.browsing--
+	e.set(e.history[e.browsing])
+}
+
+// Next replaces the line with the one entered after the line shown, or the
+// draft after the newest.
+func (e *LineEditor) Next() {
+	if e.browsing == len(e.history) {
+		return
+	}
+	e.browsing++
+	if e.browsing == len(e.history) {
+		e.set(e.draft)
+		return
+	}
+	e.set(e.history[e.browsing])
+}
+
+func (e *LineEditor) History() []string {
+	return e.history
+}
+
+func (e *LineEditor) SetHistory(history []string) {
+	e.history = history
+	e.browsing = len(e.history)
+}
+
+// AddHistory remembers an entered line, unless it is blank or repeats the
+// newest one.
+func (e *LineEditor) AddHistory(line string) {
+	line = strings.TrimSpace(line)
+	if line != "" && (len(e.history) == 0 || e.history[len(e.history)-1] != line) {
+		e.history = append(e.history, line)
+		if len(e.history) > maxCommandHistory {
+			e.history = e.history[len(e.history)-maxCommandHistory:]
+		}
+	}
+	e.browsing = len(e.history)
+}
+
+// Words splits the line before the cursor into the words already complete
+// and the partial word under the cursor, which is "" after a space.
+func (e *LineEditor) Words() ([]string, string) {
+	before := string(e.text[:e.cursor])
+	words := strings.Fields(before)
+	if len(words) == 0 || strings.HasSuffix(before, " ") {
+		return words, ""
+	}
+	return words[:len(words)-1], words[len(words)-1]
+}
+
+// Complete extends the partial word under the cursor with the candidates
+// that start with it: to the whole candidate and a space when there is only
+// one, otherwise to their longest common prefix. It returns the matching
+// candidates.
+func (e *LineEditor) Complete(candidates []string) []string {
+	_, partial := e.Words()
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, partial) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+
+	completion := matches[0]
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, completion) {
+			completion = completion[:len(completion)-1]
+		}
+	}
+	if len(matches) == 1 {
+		completion += " "
+	}
+
+	for _, r := range []rune(completion)[len([]rune(partial)):] {
+		e.Insert(r)
+	}
+	return matches
+}
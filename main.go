@@ -3,32 +3,65 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 )
 
+// enterAltScreen also turns on mouse reporting: every button press and
+// motion (1003), in the SGR format (1006) that isn't limited to 223 columns.
+// The cursor follows the mouse; motion within a cell redraws nothing.
 func enterAltScreen() {
 	fmt.Print("\033[?1049h")
 	fmt.Print("\033[?25l")
+	fmt.Print("\033[?1003h\033[?1006h")
 }
 
 func exitAltScreen() {
+	fmt.Print("\033[?1006l\033[?1003l")
 	fmt.Print("\033[?25h")
 	fmt.Print("\033[?1049l")
 }
 
-func checkAndUpdateSolution(queens Queens, config *Config, playerName string, fundamentals [][]Position) {
-	if queens.IsSolved() {
-		matchNum := FindMatchingSolution(queens.queens, fundamentals)
-		playerData := GetPlayerData(config, playerName)
-		if matchNum != -1 && playerData[matchNum-1] == 0 {
-			playerData[matchNum-1] = 1
-			SetPlayerData(config, playerName, playerData)
+// checkAndUpdateSolution records a solved board in the player's progress and
+// returns a description of which solution it is, or "" if it isn't solved.
+func checkAndUpdateSolution(queens Queens, config *Config, playerName string, index *SolutionIndex) string {
+	if !queens.IsSolved() {
+		return ""
+	}
+
+	changed := AddFoundSolution(config, playerName, queens.Size(), SolutionKey(queens.queens))
+
+	matchNum, transforms := index.Match(queens.queens)
+	if matchNum == -1 {
+		if changed {
 			SaveConfig(config)
 		}
+		return "Solved!"
+	}
+
+	playerData := GetPlayerData(config, playerName, queens.Size(), index.Len())
+	if playerData[matchNum-1] == 0 {
+		playerData[matchNum-1] = 1
+		SetPlayerData(config, playerName, queens.Size(), playerData)
+		changed = true
+	}
+
+	if AddOrientations(config, playerName, queens.Size(), matchNum, transforms) {
+		changed = true
 	}
+
+	if changed {
+		SaveConfig(config)
+	}
+
+	if transforms[0] == TransformIdentity {
+		return fmt.Sprintf("Solution %02d", matchNum)
+	}
+	return fmt.Sprintf("Solution %02d, %s", matchNum, transforms[0])
 }
 
-func countSolved(solved [12]int) int {
+func countSolved(solved []int) int {
 	count := 0
 	for _, s := range solved {
 		count += s
@@ -37,9 +70,17 @@ func countSolved(solved [12]int) int {
 }
 
 func main() {
+	if runSubcommand(os.Args[1:]) {
+		return
+	}
+
 	noExit := flag.Bool("noexit", false, "disable Esc; use :q to exit")
 	hard := flag.Bool("hard", false, "hard mode: no help, show queen validity")
 	player := flag.String("player", "", "player name for tracking progress (required)")
+	size := flag.Int("size", DefaultBoardSize, fmt.Sprintf("board size N (%d-%d; fundamental solutions are tracked up to %d)", MinBoardSize, MaxBoardSize, maxGeneratedSize))
+	boardsDir := flag.String("boards", "", "directory of board files numbering the fundamental solutions (default: built-in boards)")
+	labels := flag.String("labels", "", "board labels: none, chess (a-h, 1-8) or numeric (from 0) (default: as in the saved game, or none)")
+	keys := flag.String("keys", "", "key preset: "+strings.Join(KeyPresetNames(), ", ")+" (default: the one in ~/.queens/keys.json, or default)")
 	flag.Parse()
 
 	if *player == "" {
@@ -49,9 +90,29 @@ func main() {
 		os.Exit(1)
 	}
 
-	fundamentalSolutions, err := LoadFundamentalSolutions()
+	if err := checkPlayerName(*player); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := checkBoardSize(*size); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var labelStyle *LabelStyle
+	if *labels != "" {
+		labelStyle = new(LabelStyle)
+		if err := labelStyle.UnmarshalText([]byte(*labels)); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fundamentalSolutions, err := LoadFundamentals(*boardsDir, *size)
 	if err != nil {
-		panic(fmt.Errorf("failed to load fundamental solutions: %v", err))
+		fmt.Printf("Error: failed to load fundamental solutions: %v\n", err)
+		os.Exit(1)
 	}
 
 	config, err := LoadConfig(*player)
@@ -64,132 +125,33 @@ func main() {
 		panic(fmt.Errorf("failed to load prizes: %v", err))
 	}
 
-	playerSolved := GetPlayerData(config, *player)
+	keymap, err := LoadKeymap(*keys)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
-	terminal := RawTerminal(*noExit)
+	terminal := RawTerminal(*noExit, keymap)
 	defer terminal.Restore()
 
 	enterAltScreen()
 	defer exitAltScreen()
 
-	queens := NewQueens()
-	cursorRow, cursorCol := 0, 0
-	showHelp := false
-	commandMode := false
-	commandBuffer := ""
-
-	renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
+	game := NewGame(&terminal, NewANSIRenderer(os.Stdout), *boardsDir, config, *player, *size, fundamentalSolutions, prizes, *noExit, *hard, labelStyle)
+	game.RestoreAutosave()
+	game.render()
 
 	for {
-		if cmd, err := terminal.ReadInput(); err == nil {
-			if commandMode {
-				switch cmd.Code {
-				case CodeExit, CodeCancelCommand:
-					commandMode = false
-					terminal.SetCommandMode(false)
-					commandBuffer = ""
-					playerSolved = GetPlayerData(config, *player)
-					renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-				case CodePlace:
-					if commandBuffer == ":q" {
-						return
-					}
-					commandMode = false
-					terminal.SetCommandMode(false)
-					commandBuffer = ""
-					playerSolved = GetPlayerData(config, *player)
-					renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-				case CodeChar:
-					if data, ok := cmd.Data.(rune); ok {
-						commandBuffer += string(data)
-						renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-					}
-				}
-				continue
-			}
-
-			switch cmd.Code {
-			case CodeExit:
-				return
-
-			case CodeCommand:
-				commandMode = true
-				terminal.SetCommandMode(true)
-				commandBuffer = ":"
-				renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-
-			case CodeReset:
-				queens.Reset()
-				cursorRow, cursorCol = 0, 0
-				renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-
-			case CodeHelp:
-				if !*hard {
-					showHelp = !showHelp
-					renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-				}
-
-			case CodeSymbolBlack:
-				queens.SetSymbol(SymbolBlack)
-				renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-
-			case CodeSymbolWhite:
-				queens.SetSymbol(SymbolWhite)
-				renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-
-			case CodeSymbolAscii:
-				queens.SetSymbol(SymbolAscii)
-				renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-
-			case CodePlace:
-				if queens.HasQueen(cursorRow, cursorCol) {
-					queens.RemoveQueen(cursorRow, cursorCol)
-					renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-				} else if queens.Count() < 8 {
-					if *hard {
-						queens.queens = append(queens.queens, Position{Row: cursorRow, Col: cursorCol})
-						checkAndUpdateSolution(queens, config, *player, fundamentalSolutions)
-						playerSolved = GetPlayerData(config, *player)
-						renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-					} else {
-						if err := queens.PlaceQueen(cursorRow, cursorCol); err == nil {
-							checkAndUpdateSolution(queens, config, *player, fundamentalSolutions)
-							playerSolved = GetPlayerData(config, *player)
-							renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-						} else {
-							renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-						}
-					}
-				}
-
-			case CodeUp:
-				if cursorRow > 0 {
-					cursorRow--
-					renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-				}
-
-			case CodeDown:
-				if cursorRow < boardSize-1 {
-					cursorRow++
-					renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-				}
-
-			case CodeLeft:
-				if cursorCol > 0 {
-					cursorCol--
-					renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-				}
-
-			case CodeRight:
-				if cursorCol < boardSize-1 {
-					cursorCol++
-					renderScreen(queens, cursorRow, cursorCol, showHelp, *noExit, *hard, commandBuffer, playerSolved, prizes)
-				}
-
-			case CodeNone:
-			}
-		} else {
-			panic("error reading from terminal")
+		cmd, err := terminal.ReadInput()
+		if err == io.EOF {
+			game.Autosave()
+			return
+		} else if err != nil {
+			panic(err)
+		}
+		if game.Handle(cmd) {
+			game.Autosave()
+			return
 		}
 	}
 }
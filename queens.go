@@ -3,11 +3,14 @@ package main
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 )
 
 const (
-	boardSize = 8
+	DefaultBoardSize = 8
+	MinBoardSize     = 4
+	MaxBoardSize     = 20
 )
 
 var (
@@ -18,8 +21,8 @@ var (
 )
 
 type Position struct {
-	Row int
-	Col int
+	Row int `json:"row"`
+	Col int `json:"col"`
 }
 
 type QueenSymbol int
@@ -30,22 +33,96 @@ const (
 	SymbolAscii
 )
 
+var symbolNames = map[QueenSymbol]string{
+	SymbolBlack: "black",
+	SymbolWhite: "white",
+	SymbolAscii: "ascii",
+}
+
+func (s QueenSymbol) MarshalText() ([]byte, error) {
+	name, ok := symbolNames[s]
+	if !ok {
+		return nil, fmt.Errorf("unknown symbol %d", int(s))
+	}
+	return []byte(name), nil
+}
+
+func (s *QueenSymbol) UnmarshalText(text []byte) error {
+	for symbol, name := range symbolNames {
+		if name == string(text) {
+			*s = symbol
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown symbol %q", text)
+}
+
+// Queens keeps the placed queens both as a list, in placement order, and as
+// bitmasks of the occupied cells and lines so that attack checks don't have
+// to scan the list.
 type Queens struct {
-	queens []Position
-	symbol QueenSymbol
+	queens    []Position
+	symbol    QueenSymbol
+	size      int
+	history   History
+	cells     bitset
+	rows      lineSet
+	cols      lineSet
+	diags     lineSet
+	antiDiags lineSet
 }
 
-func NewQueens() Queens {
+func NewQueens(size int) Queens {
 	return Queens{
-		queens: make([]Position, 0, boardSize),
-		symbol: SymbolBlack,
+		queens:    make([]Position, 0, size),
+		symbol:    SymbolBlack,
+		size:      size,
+		cells:     newBitset(size * size),
+		rows:      newLineSet(size),
+		cols:      newLineSet(size),
+		diags:     newLineSet(2*size - 1),
+		antiDiags: newLineSet(2*size - 1),
+	}
+}
+
+// NewQueensFrom returns a board with the given queens placed without any
+// attack checks, as hard mode allows.
+func NewQueensFrom(size int, positions []Position) Queens {
+	q := NewQueens(size)
+	for _, pos := range positions {
+		q.PlaceQueenUnchecked(pos.Row, pos.Col)
 	}
+	q.history = History{}
+	return q
+}
+
+// Clone returns a copy of the board that shares nothing with it. A copy by
+// value shares the list and the masks, so a queen placed on it would be
+// counted on the original too.
+func (q *Queens) Clone() Queens {
+	clone := *q
+	clone.queens = slices.Clone(q.queens)
+	clone.history = History{Done: slices.Clone(q.history.Done), Undone: slices.Clone(q.history.Undone)}
+	clone.cells = slices.Clone(q.cells)
+	clone.rows = q.rows.clone()
+	clone.cols = q.cols.clone()
+	clone.diags = q.diags.clone()
+	clone.antiDiags = q.antiDiags.clone()
+	return clone
+}
+
+func (q *Queens) Size() int {
+	return q.size
 }
 
 func (q *Queens) SetSymbol(symbol QueenSymbol) {
 	q.symbol = symbol
 }
 
+func (q *Queens) Symbol() QueenSymbol {
+	return q.symbol
+}
+
 func (q *Queens) GetSymbol() string {
 	switch q.symbol {
 	case SymbolBlack:
@@ -60,7 +137,7 @@ func (q *Queens) GetSymbol() string {
 }
 
 func (q *Queens) PlaceQueen(row, col int) error {
-	if !inBounds(row, col) {
+	if !q.inBounds(row, col) {
 		return ErrOutOfBounds
 	}
 
@@ -72,102 +149,105 @@ func (q *Queens) PlaceQueen(row, col int) error {
 		return ErrUnderAttack
 	}
 
-	q.queens = append(q.queens, Position{Row: row, Col: col})
+	q.add(row, col)
+	q.record(Move{Kind: MovePlace, Row: row, Col: col})
+	return nil
+}
+
+// PlaceQueenUnchecked places a queen even if the cell is under attack.
+func (q *Queens) PlaceQueenUnchecked(row, col int) error {
+	if !q.inBounds(row, col) {
+		return ErrOutOfBounds
+	}
+
+	if q.HasQueen(row, col) {
+		return ErrOccupied
+	}
+
+	q.add(row, col)
+	q.record(Move{Kind: MovePlace, Row: row, Col: col})
 	return nil
 }
 
+// add puts a queen on an empty cell, reporting false for an occupied one
+// or one off the board.
+func (q *Queens) add(row, col int) bool {
+	if !q.inBounds(row, col) || q.HasQueen(row, col) {
+		return false
+	}
+	q.queens = append(q.queens, Position{Row: row, Col: col})
+	q.cells.set(row*q.size + col)
+	q.rows.add(row)
+	q.cols.add(col)
+	q.diags.add(q.diagonal(row, col))
+	q.antiDiags.add(q.antiDiagonal(row, col))
+	return true
+}
+
 func (q *Queens) RemoveQueen(row, col int) error {
-	if !inBounds(row, col) {
+	if !q.inBounds(row, col) {
 		return ErrOutOfBounds
 	}
 
+	if !q.remove(row, col) {
+		return ErrNoQueenToRemove
+	}
+
+	q.record(Move{Kind: MoveRemove, Row: row, Col: col})
+	return nil
+}
+
+func (q *Queens) remove(row, col int) bool {
 	for i, pos := range q.queens {
 		if pos.Row == row && pos.Col == col {
 			// Remove queen at index i
 			q.queens = append(q.queens[:i], q.queens[i+1:]...)
-			return nil
+			q.cells.clear(row*q.size + col)
+			q.rows.remove(row)
+			q.cols.remove(col)
+			q.diags.remove(q.diagonal(row, col))
+			q.antiDiags.remove(q.antiDiagonal(row, col))
+			return true
 		}
 	}
-
-	return ErrNoQueenToRemove
+	return false
 }
 
 func (q *Queens) HasQueen(row, col int) bool {
-	for _, pos := range q.queens {
-		if pos.Row == row && pos.Col == col {
-			return true
-		}
+	if !q.inBounds(row, col) {
+		return false
 	}
-	return false
+	return q.cells.has(row*q.size + col)
 }
 
 func (q *Queens) IsUnderAttack(row, col int) bool {
-	for _, queen := range q.queens {
-		if queen.Row == row {
-			return true
-		}
-		if queen.Col == col {
-			return true
-		}
-		rowDiff := abs(queen.Row - row)
-		colDiff := abs(queen.Col - col)
-		if rowDiff == colDiff {
-			return true
-		}
+	if !q.inBounds(row, col) {
+		return false
 	}
-	return false
+	return q.rows.has(row) ||
+		q.cols.has(col) ||
+		q.diags.has(q.diagonal(row, col)) ||
+		q.antiDiags.has(q.antiDiagonal(row, col))
 }
 
 // IsQueenUnderAttack checks if a queen at the given position is under attack by any OTHER queen
 func (q *Queens) IsQueenUnderAttack(row, col int) bool {
-	for _, queen := range q.queens {
-		if queen.Row == row && queen.Col == col {
-			continue
-		}
-
-		if queen.Row == row {
-			return true
-		}
-		if queen.Col == col {
-			return true
-		}
-		rowDiff := abs(queen.Row - row)
-		colDiff := abs(queen.Col - col)
-		if rowDiff == colDiff {
-			return true
-		}
+	if !q.HasQueen(row, col) {
+		return q.IsUnderAttack(row, col)
 	}
-	return false
+	return q.rows.shared(row) ||
+		q.cols.shared(col) ||
+		q.diags.shared(q.diagonal(row, col)) ||
+		q.antiDiags.shared(q.antiDiagonal(row, col))
 }
 
 func (q *Queens) GetAttackedPositions() map[Position]bool {
 	attacked := make(map[Position]bool)
 
-	for _, queen := range q.queens {
-		for col := 0; col < boardSize; col++ {
-			if col != queen.Col {
-				attacked[Position{Row: queen.Row, Col: col}] = true
-			}
-		}
-
-		for row := 0; row < boardSize; row++ {
-			if row != queen.Row {
-				attacked[Position{Row: row, Col: queen.Col}] = true
-			}
-		}
-
-		for i := 1; i < boardSize; i++ {
-			if inBounds(queen.Row-i, queen.Col-i) {
-				attacked[Position{Row: queen.Row - i, Col: queen.Col - i}] = true
-			}
-			if inBounds(queen.Row-i, queen.Col+i) {
-				attacked[Position{Row: queen.Row - i, Col: queen.Col + i}] = true
-			}
-			if inBounds(queen.Row+i, queen.Col-i) {
-				attacked[Position{Row: queen.Row + i, Col: queen.Col - i}] = true
-			}
-			if inBounds(queen.Row+i, queen.Col+i) {
-				attacked[Position{Row: queen.Row + i, Col: queen.Col + i}] = true
+	for row := 0; row < q.size; row++ {
+		for col := 0; col < q.size; col++ {
+			if !q.HasQueen(row, col) && q.IsUnderAttack(row, col) {
+				attacked[Position{Row: row, Col: col}] = true
 			}
 		}
 	}
@@ -180,111 +260,136 @@ func (q *Queens) Count() int {
 }
 
 func (q *Queens) IsSolved() bool {
-	if len(q.queens) != boardSize {
+	if len(q.queens) != q.size {
 		return false
 	}
 
-	for _, queen := range q.queens {
-		if q.IsQueenUnderAttack(queen.Row, queen.Col) {
-			return false
-		}
-	}
-
-	return true
+	return q.rows.crowded == 0 &&
+		q.cols.crowded == 0 &&
+		q.diags.crowded == 0 &&
+		q.antiDiags.crowded == 0
 }
 
 func (q *Queens) Reset() {
-	q.queens = make([]Position, 0, boardSize)
-}
+	if len(q.queens) == 0 {
+		return
+	}
 
-func (q *Queens) Pretty(cursorRow, cursorCol int, showAttacked bool, hardMode bool) string {
-	var result strings.Builder
+	q.record(Move{Kind: MoveReset, Queens: append([]Position(nil), q.queens...)})
+	q.clear()
+}
 
-	attacked := make(map[Position]bool)
-	if showAttacked && !hardMode {
-		attacked = q.GetAttackedPositions()
+// Load replaces the queens on the board with positions, as one move that
+// can be undone. Positions must be on the board, but may attack each other.
+func (q *Queens) Load(positions []Position) error {
+	for _, pos := range positions {
+		if !q.inBounds(pos.Row, pos.Col) {
+			return ErrOutOfBounds
+		}
 	}
 
-	queenSymbol := q.GetSymbol()
+	q.record(Move{
+		Kind:     MoveLoad,
+		Queens:   append([]Position(nil), positions...),
+		Previous: append([]Position(nil), q.queens...),
+	})
+	q.replace(positions)
+	return nil
+}
 
-	result.WriteString("┌")
-	for col := 0; col < boardSize; col++ {
-		result.WriteString("───")
-		if col < boardSize-1 {
-			result.WriteString("┬")
-		}
+func (q *Queens) replace(positions []Position) {
+	q.clear()
+	for _, pos := range positions {
+		q.add(pos.Row, pos.Col)
 	}
-	result.WriteString("┐\n")
+}
 
-	for row := 0; row < boardSize; row++ {
-		result.WriteString("│")
+func (q *Queens) clear() {
+	q.queens = make([]Position, 0, q.size)
+	q.cells.reset()
+	q.rows.reset()
+	q.cols.reset()
+	q.diags.reset()
+	q.antiDiags.reset()
+}
 
-		for col := 0; col < boardSize; col++ {
-			isCursor := (row == cursorRow && col == cursorCol)
-			hasQueen := q.HasQueen(row, col)
-			isAttacked := attacked[Position{Row: row, Col: col}]
-
-			if hasQueen {
-				if hardMode {
-					queenUnderAttack := q.IsQueenUnderAttack(row, col)
-					if isCursor {
-						result.WriteString(fmt.Sprintf("\033[1;33;7m %s \033[0m", queenSymbol))
-					} else if queenUnderAttack {
-						result.WriteString(fmt.Sprintf("\033[1;31;7m %s \033[0m", queenSymbol))
-					} else {
-						result.WriteString(fmt.Sprintf("\033[1;32m %s \033[0m", queenSymbol))
-					}
-				} else {
-					if isCursor {
-						result.WriteString(fmt.Sprintf("\033[1;7m %s \033[0m", queenSymbol))
-					} else {
-						result.WriteString(fmt.Sprintf("\033[1m %s \033[0m", queenSymbol))
-					}
-				}
-			} else if isCursor {
-				result.WriteString("\033[1;7m   \033[0m")
-			} else if isAttacked {
-				result.WriteString("\033[41m   \033[0m")
-			} else {
-				result.WriteString("   ")
-			}
+// Cell is what one cell of the board shows.
+type Cell int
 
-			result.WriteString("│")
-		}
+const (
+	CellEmpty Cell = iota
+	// CellAttacked is an empty cell a queen attacks, shown with help on.
+	CellAttacked
+	CellHint
+	CellCursor
+	CellQueen
+	CellQueenCursor
+	// CellQueenSafe and CellQueenAttacked are queens in hard mode, which
+	// shows whether another queen attacks them.
+	CellQueenSafe
+	CellQueenAttacked
+)
 
-		result.WriteString("\n")
+// BoardView is the board as it is to be drawn, with the labels of its
+// files and ranks, if any, from the top-left corner.
+type BoardView struct {
+	Size   int
+	Symbol string
+	Hard   bool
+	Cells  [][]Cell
+	Files  []string
+	Ranks  []string
+}
+
+// View works out what each cell of the board shows. A non-nil hint is
+// highlighted unless the cursor is on it.
+func (q *Queens) View(cursorRow, cursorCol int, showAttacked bool, hardMode bool, hint *Position) BoardView {
+	showAttacked = showAttacked && !hardMode
 
-		if row < boardSize-1 {
-			result.WriteString("├")
-			for col := 0; col < boardSize; col++ {
-				result.WriteString("───")
-				if col < boardSize-1 {
-					result.WriteString("┼")
-				}
+	view := BoardView{Size: q.size, Symbol: q.GetSymbol(), Hard: hardMode, Cells: make([][]Cell, q.size)}
+	for row := 0; row < q.size; row++ {
+		view.Cells[row] = make([]Cell, q.size)
+		for col := 0; col < q.size; col++ {
+			isCursor := (row == cursorRow && col == cursorCol)
+
+			var cell Cell
+			switch {
+			case q.HasQueen(row, col) && isCursor:
+				cell = CellQueenCursor
+			case q.HasQueen(row, col) && hardMode && q.IsQueenUnderAttack(row, col):
+				cell = CellQueenAttacked
+			case q.HasQueen(row, col) && hardMode:
+				cell = CellQueenSafe
+			case q.HasQueen(row, col):
+				cell = CellQueen
+			case isCursor:
+				cell = CellCursor
+			case hint != nil && hint.Row == row && hint.Col == col:
+				cell = CellHint
+			case showAttacked && q.IsUnderAttack(row, col):
+				cell = CellAttacked
 			}
-			result.WriteString("┤\n")
+			view.Cells[row][col] = cell
 		}
 	}
+	return view
+}
 
-	result.WriteString("└")
-	for col := 0; col < boardSize; col++ {
-		result.WriteString("───")
-		if col < boardSize-1 {
-			result.WriteString("┴")
-		}
-	}
-	result.WriteString("┘")
+// Pretty draws the board with ANSI colours.
+func (q *Queens) Pretty(cursorRow, cursorCol int, showAttacked bool, hardMode bool, hint *Position) string {
+	return strings.Join(boardLines(q.View(cursorRow, cursorCol, showAttacked, hardMode, hint), true), "\n")
+}
 
-	return result.String()
+func (q *Queens) inBounds(row, col int) bool {
+	return row >= 0 && row < q.size && col >= 0 && col < q.size
 }
 
-func inBounds(row, col int) bool {
-	return row >= 0 && row < boardSize && col >= 0 && col < boardSize
+// diagonal numbers the top-left to bottom-right diagonals from 0 to 2*size-2.
+func (q *Queens) diagonal(row, col int) int {
+	return row - col + q.size - 1
 }
 
-func abs(x int) int {
-	if x < 0 {
-		return -x
-	}
-	return x
+// antiDiagonal numbers the top-right to bottom-left diagonals from 0 to 2*size-2.
+func (q *Queens) antiDiagonal(row, col int) int {
+	return row + col
 }
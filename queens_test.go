@@ -1,14 +1,21 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func TestQueensPlacement(t *testing.T) {
-	q := NewQueens()
+	q := NewQueens(DefaultBoardSize)
 
 	// Should be able to place first queen
 	if err := q.PlaceQueen(0, 0); err != nil {
@@ -41,7 +48,7 @@ func TestQueensPlacement(t *testing.T) {
 }
 
 func TestQueensRemoval(t *testing.T) {
-	q := NewQueens()
+	q := NewQueens(DefaultBoardSize)
 
 	// Place a queen
 	q.PlaceQueen(0, 0)
@@ -62,7 +69,7 @@ func TestQueensRemoval(t *testing.T) {
 }
 
 func TestQueensAttackDetection(t *testing.T) {
-	q := NewQueens()
+	q := NewQueens(DefaultBoardSize)
 	q.PlaceQueen(3, 3)
 
 	tests := []struct {
@@ -91,7 +98,7 @@ func TestQueensAttackDetection(t *testing.T) {
 }
 
 func TestQueensSolution(t *testing.T) {
-	q := NewQueens()
+	q := NewQueens(DefaultBoardSize)
 
 	// One valid solution for 8-queens
 	solution := []Position{
@@ -121,7 +128,7 @@ func TestQueensSolution(t *testing.T) {
 }
 
 func TestQueensSymbol(t *testing.T) {
-	q := NewQueens()
+	q := NewQueens(DefaultBoardSize)
 
 	// Default should be black
 	if q.GetSymbol() != "♛" {
@@ -141,7 +148,7 @@ func TestQueensSymbol(t *testing.T) {
 }
 
 func TestQueensReset(t *testing.T) {
-	q := NewQueens()
+	q := NewQueens(DefaultBoardSize)
 
 	// Place some queens
 	q.PlaceQueen(0, 0)
@@ -165,6 +172,31 @@ func TestQueensReset(t *testing.T) {
 	}
 }
 
+func TestQueensClone(t *testing.T) {
+	q := NewQueens(DefaultBoardSize)
+	q.PlaceQueen(0, 0)
+	q.PlaceQueen(1, 2)
+	q.PlaceQueen(2, 4)
+
+	clone := q.Clone()
+	clone.PlaceQueen(3, 1)
+	clone.RemoveQueen(0, 0)
+	clone.Undo()
+	if q.Count() != 3 || q.HasQueen(3, 1) || !q.HasQueen(0, 0) || q.IsUnderAttack(4, 1) {
+		t.Errorf("Changing the clone changed the original:\n%s", q.Grid())
+	}
+	if len(q.History().Done) != 3 {
+		t.Errorf("Expected the original's 3 moves, got %v", q.History().Done)
+	}
+
+	save := SavedGame{Board: q, History: q.History()}
+	restored := save.Queens()
+	restored.PlaceQueen(3, 1)
+	if save.Board.HasQueen(3, 1) || save.Board.IsUnderAttack(4, 1) {
+		t.Errorf("Playing a restored game changed the save:\n%s", save.Board.Grid())
+	}
+}
+
 func TestFundamentalSolutions(t *testing.T) {
 	files, err := filepath.Glob("boards/*.txt")
 	if err != nil {
@@ -206,10 +238,7 @@ func TestFundamentalSolutions(t *testing.T) {
 				positions = append(positions, Position{Row: row, Col: col})
 			}
 
-			q := Queens{
-				queens: positions,
-				symbol: SymbolBlack,
-			}
+			q := NewQueensFrom(DefaultBoardSize, positions)
 
 			if !q.IsSolved() {
 				t.Errorf("Board is not a valid solution. Positions: %v", positions)
@@ -221,3 +250,1863 @@ func TestFundamentalSolutions(t *testing.T) {
 		})
 	}
 }
+
+func TestQueensBoardSizes(t *testing.T) {
+	solutions := map[int][]int{
+		4:  {1, 3, 0, 2},
+		6:  {1, 3, 5, 0, 2, 4},
+		10: {0, 2, 5, 7, 9, 4, 8, 1, 3, 6},
+	}
+
+	for size, cols := range solutions {
+		q := NewQueens(size)
+
+		if err := q.PlaceQueen(size, 0); err != ErrOutOfBounds {
+			t.Errorf("size %d: expected ErrOutOfBounds, got %v", size, err)
+		}
+
+		for row, col := range cols {
+			if err := q.PlaceQueen(row, col); err != nil {
+				t.Fatalf("size %d: failed to place queen at (%d, %d): %v", size, row, col, err)
+			}
+		}
+
+		if !q.IsSolved() {
+			t.Errorf("size %d: expected puzzle to be solved", size)
+		}
+
+		lines := strings.Split(q.Pretty(0, 0, false, false, nil), "\n")
+		if len(lines) != 2*size+1 {
+			t.Errorf("size %d: expected %d lines in Pretty, got %d", size, 2*size+1, len(lines))
+		}
+	}
+}
+
+func TestSolverCounts(t *testing.T) {
+	expected := map[int]int{4: 2, 5: 10, 6: 4, 7: 40, 8: 92, 9: 352, 10: 724}
+
+	for size, count := range expected {
+		if got := NewSolver(size).Count(0); got != count {
+			t.Errorf("size %d: expected %d solutions, got %d", size, count, got)
+		}
+	}
+
+	if got := NewSolver(8).Count(5); got != 5 {
+		t.Errorf("Expected Count to stop at limit 5, got %d", got)
+	}
+}
+
+func TestCountReachable(t *testing.T) {
+	// No 6x6 solution has a queen in a corner.
+	g := newTestGame(t, 6)
+	g.execute(":place a6")
+	if g.reachable != 0 || !strings.HasPrefix(formatReachable(g.reachable), "Dead end") {
+		t.Errorf("Expected a dead end, got %d: %q", g.reachable, formatReachable(g.reachable))
+	}
+
+	g = newTestGame(t, 12)
+	if g.countReachable(); g.reachable != maxReachableCount || formatReachable(g.reachable) != "1000+ solutions still reachable" {
+		t.Errorf("Expected the count to stop at %d, got %d: %q", maxReachableCount, g.reachable, formatReachable(g.reachable))
+	}
+
+	// Starting a session counts whether or not a game is restored.
+	g = newTestGame(t, DefaultBoardSize)
+	if g.RestoreAutosave(); g.reachable != 92 {
+		t.Errorf("Expected 92 solutions reachable on a new board, got %d", g.reachable)
+	}
+	g.execute(":place e4")
+	g.Autosave()
+	restored := NewGame(g.terminal, &recordingRenderer{}, "", g.config, "alice", DefaultBoardSize, g.fundamentals, nil, false, false, nil)
+	if restored.RestoreAutosave(); restored.queens.Count() != 1 || restored.reachable != g.reachable {
+		t.Errorf("Expected %d solutions reachable after restoring, got %d", g.reachable, restored.reachable)
+	}
+
+	count, ok := NewSolver(MaxBoardSize).CountWithin(maxReachableCount, 100)
+	if ok || count != 0 {
+		t.Errorf("Expected the search to run out of nodes, got %d, %v", count, ok)
+	}
+	if count, ok := NewSolver(8).CountWithin(0, maxReachableNodes); !ok || count != 92 {
+		t.Errorf("Expected all 92 solutions within the budget, got %d, %v", count, ok)
+	}
+	if !strings.Contains(formatReachable(reachableUnknown), "unknown") {
+		t.Errorf("Expected an unknown count, got %q", formatReachable(reachableUnknown))
+	}
+}
+
+// captureStdout returns what run prints to stdout.
+func captureStdout(t *testing.T, run func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	runErr := run()
+	os.Stdout = stdout
+	w.Close()
+
+	output, _ := io.ReadAll(r)
+	if runErr != nil {
+		t.Fatalf("Failed: %v", runErr)
+	}
+	return string(output)
+}
+
+func TestRunSolveStreams(t *testing.T) {
+	var solutions []solutionJSON
+	output := captureStdout(t, func() error { return runSolve([]string{"-size", "6", "-format", "json", "-limit", "3"}) })
+	if err := json.Unmarshal([]byte(output), &solutions); err != nil || len(solutions) != 3 {
+		t.Errorf("Expected 3 solutions as a JSON array, got %v:\n%s", err, output)
+	}
+	// No 4x4 solution has a queen in a corner.
+	board := filepath.Join(t.TempDir(), "board.txt")
+	if err := os.WriteFile(board, []byte("Q...\n....\n....\n....\n"), 0644); err != nil {
+		t.Fatalf("Failed to write board: %v", err)
+	}
+	if output := captureStdout(t, func() error { return runSolve([]string{"-format", "json", "-board", board}) }); output != "[]\n" {
+		t.Errorf("Expected an empty array, got %q", output)
+	}
+	if output := captureStdout(t, func() error { return runSolve([]string{"-size", "6"}) }); strings.Count(output, "Q") != 24 {
+		t.Errorf("Expected the 4 solutions drawn, got\n%s", output)
+	}
+}
+
+func TestRunSolveSizeMismatch(t *testing.T) {
+	board := filepath.Join(t.TempDir(), "board.txt")
+	if err := os.WriteFile(board, []byte(FormatBoard([]Position{{Row: 0, Col: 1}}, 6)), 0644); err != nil {
+		t.Fatalf("Failed to write board: %v", err)
+	}
+	if err := runSolve([]string{"-size", "8", "-board", board}); err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Errorf("Expected a size mismatch error, got %v", err)
+	}
+
+	bad := []struct {
+		content string
+		err     error
+	}{
+		{"....Q.....Q\n", ErrBadGrid},
+		{"....\n.X..\n....\n....\n", ErrBadGrid},
+		{"QQ....\n......\n......\n......\n......\n......\n", ErrInvalidPartial},
+	}
+	for _, tt := range bad {
+		if err := os.WriteFile(board, []byte(tt.content), 0644); err != nil {
+			t.Fatalf("Failed to write board: %v", err)
+		}
+		if err := runSolve([]string{"-board", board}); !errors.Is(err, tt.err) {
+			t.Errorf("Expected %v for the board\n%s, got %v", tt.err, tt.content, err)
+		}
+	}
+}
+
+func TestSolverPartial(t *testing.T) {
+	q := NewQueens(DefaultBoardSize)
+	q.PlaceQueen(0, 0)
+	q.PlaceQueen(1, 4)
+
+	solver, err := NewSolverFrom(&q)
+	if err != nil {
+		t.Fatalf("Failed to create solver: %v", err)
+	}
+
+	solutions := solver.Solutions(0)
+	if len(solutions) == 0 {
+		t.Fatalf("Expected at least one completion")
+	}
+
+	for _, solution := range solutions {
+		if solution[0].Col != 0 || solution[1].Col != 4 {
+			t.Errorf("Completion does not keep the placed queens: %v", solution)
+		}
+
+		board := NewQueensFrom(DefaultBoardSize, solution)
+		if !board.IsSolved() {
+			t.Errorf("Completion is not a solution: %v", solution)
+		}
+	}
+
+	q.PlaceQueenUnchecked(2, 2)
+	if _, err := NewSolverFrom(&q); err != ErrInvalidPartial {
+		t.Errorf("Expected ErrInvalidPartial for attacking queens, got %v", err)
+	}
+}
+
+func TestFormatBoardRoundTrip(t *testing.T) {
+	content, err := os.ReadFile("boards/s01.txt")
+	if err != nil {
+		t.Fatalf("Failed to read board: %v", err)
+	}
+
+	positions, size := ParseBoard(string(content))
+	if size != 8 || len(positions) != 8 {
+		t.Fatalf("Expected 8 queens on 8 rows, got %d on %d", len(positions), size)
+	}
+
+	if got := FormatBoard(positions, size); got != string(content) {
+		t.Errorf("FormatBoard did not reproduce the file:\n%s", got)
+	}
+}
+
+func TestGenerateFundamentalSolutions(t *testing.T) {
+	expected := map[int]int{4: 1, 5: 2, 6: 1, 7: 6, 8: 12, 9: 46, 10: 92}
+
+	for size, count := range expected {
+		fundamentals := GenerateFundamentalSolutions(size, nil)
+		if len(fundamentals) != count {
+			t.Errorf("size %d: expected %d fundamentals, got %d", size, count, len(fundamentals))
+		}
+
+		variants := 0
+		for _, fundamental := range fundamentals {
+			symmetries := NewSymmetries(fundamental, size)
+			seen := make(map[string]bool)
+			for _, transform := range symmetries.transforms {
+				seen[FormatBoard(transform, size)] = true
+			}
+			variants += len(seen)
+		}
+		if total := NewSolver(size).Count(0); variants != total {
+			t.Errorf("size %d: fundamentals cover %d solutions, expected %d", size, variants, total)
+		}
+	}
+}
+
+func TestGenerateFundamentalSolutionsBoardOrder(t *testing.T) {
+	files, err := filepath.Glob("boards/*.txt")
+	if err != nil {
+		t.Fatalf("Failed to read boards directory: %v", err)
+	}
+
+	boardsFS, err := BoardsFS("")
+	if err != nil {
+		t.Fatalf("Failed to open embedded boards: %v", err)
+	}
+
+	boards, err := LoadFundamentalSolutions(boardsFS, DefaultBoardSize)
+	if err != nil {
+		t.Fatalf("Failed to load embedded boards: %v", err)
+	}
+
+	// The classic numbering comes from the generator itself, with or
+	// without the board files.
+	for _, from := range [][][]Position{nil, boards} {
+		fundamentals := GenerateFundamentalSolutions(DefaultBoardSize, from)
+		if len(fundamentals) != len(files) {
+			t.Fatalf("Expected %d fundamentals, got %d", len(files), len(fundamentals))
+		}
+
+		for i, file := range files {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("Failed to read file: %v", err)
+			}
+
+			if got := FormatBoard(fundamentals[i], DefaultBoardSize); got != string(content) {
+				t.Errorf("Fundamental %d does not match %s:\n%s", i+1, file, got)
+			}
+		}
+	}
+}
+
+func TestBoardsFSOverride(t *testing.T) {
+	embedded, err := BoardsFS("")
+	if err != nil {
+		t.Fatalf("Failed to open embedded boards: %v", err)
+	}
+
+	dir, err := BoardsFS("boards")
+	if err != nil {
+		t.Fatalf("Failed to open boards directory: %v", err)
+	}
+
+	fromEmbedded, err := LoadFundamentalSolutions(embedded, DefaultBoardSize)
+	if err != nil {
+		t.Fatalf("Failed to load embedded boards: %v", err)
+	}
+
+	fromDir, err := LoadFundamentalSolutions(dir, DefaultBoardSize)
+	if err != nil {
+		t.Fatalf("Failed to load boards directory: %v", err)
+	}
+
+	if len(fromEmbedded) != 12 || len(fromDir) != 12 {
+		t.Fatalf("Expected 12 boards from each source, got %d and %d", len(fromEmbedded), len(fromDir))
+	}
+
+	for i := range fromDir {
+		if !positionsEqual(fromEmbedded[i], fromDir[i]) {
+			t.Errorf("Board %d differs between embedded and directory sources", i+1)
+		}
+	}
+}
+
+func TestBoardsKeepNumbering(t *testing.T) {
+	if _, err := BoardsFS(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected an error for a missing boards directory")
+	}
+
+	// The same boards with the first two swapped, and the first one drawn
+	// mirrored, still number the fundamentals the classic way.
+	dir := t.TempDir()
+	files, _ := filepath.Glob("boards/*.txt")
+	for i, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read file: %v", err)
+		}
+		name := filepath.Base(file)
+		if i < 2 {
+			name = filepath.Base(files[1-i])
+		}
+		if i == 0 {
+			positions, _ := ParseBoard(string(content))
+			content = []byte(FormatBoard(TransformMirrorH.applyAll(positions, DefaultBoardSize), DefaultBoardSize))
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatalf("Failed to write board: %v", err)
+		}
+	}
+
+	fundamentals, err := LoadFundamentals(dir, DefaultBoardSize)
+	if err != nil {
+		t.Fatalf("Failed to load boards: %v", err)
+	}
+	builtIn, err := LoadFundamentals("", DefaultBoardSize)
+	if err != nil {
+		t.Fatalf("Failed to load boards: %v", err)
+	}
+	for i := range builtIn {
+		if CanonicalKey(fundamentals[i], DefaultBoardSize) != CanonicalKey(builtIn[i], DefaultBoardSize) {
+			t.Errorf("Fundamental %d was renumbered by the board files", i+1)
+		}
+	}
+	if got := SolutionKey(fundamentals[0]); got != "41506372" {
+		t.Errorf("Fundamental 1 should be drawn as in the board file, got %s", got)
+	}
+}
+
+// naiveIsUnderAttack is the list-scanning attack check that Queens used
+// before it kept bitmasks, kept here as a baseline for the benchmarks.
+func naiveIsUnderAttack(queens []Position, row, col int) bool {
+	for _, queen := range queens {
+		if queen.Row == row || queen.Col == col {
+			return true
+		}
+		rowDiff := queen.Row - row
+		colDiff := queen.Col - col
+		if rowDiff == colDiff || rowDiff == -colDiff {
+			return true
+		}
+	}
+	return false
+}
+
+func naiveHasQueen(queens []Position, row, col int) bool {
+	for _, pos := range queens {
+		if pos.Row == row && pos.Col == col {
+			return true
+		}
+	}
+	return false
+}
+
+func naiveAttackedPositions(queens []Position, size int) map[Position]bool {
+	attacked := make(map[Position]bool)
+	inBounds := func(row, col int) bool {
+		return row >= 0 && row < size && col >= 0 && col < size
+	}
+
+	for _, queen := range queens {
+		for i := 0; i < size; i++ {
+			if i != queen.Col {
+				attacked[Position{Row: queen.Row, Col: i}] = true
+			}
+			if i != queen.Row {
+				attacked[Position{Row: i, Col: queen.Col}] = true
+			}
+		}
+
+		for i := 1; i < size; i++ {
+			for _, d := range []Position{{-i, -i}, {-i, i}, {i, -i}, {i, i}} {
+				if inBounds(queen.Row+d.Row, queen.Col+d.Col) {
+					attacked[Position{Row: queen.Row + d.Row, Col: queen.Col + d.Col}] = true
+				}
+			}
+		}
+	}
+
+	return attacked
+}
+
+func TestQueensBitboardMatchesNaive(t *testing.T) {
+	for _, size := range []int{4, 8, 11, 20} {
+		q := NewQueens(size)
+		// Hard mode placements, including queens sharing lines.
+		for _, pos := range []Position{{0, 1}, {1, 3}, {2, 1}, {3, 3}, {size - 1, size - 1}} {
+			q.PlaceQueenUnchecked(pos.Row, pos.Col)
+		}
+		q.RemoveQueen(1, 3)
+
+		for row := 0; row < size; row++ {
+			for col := 0; col < size; col++ {
+				if got, want := q.IsUnderAttack(row, col), naiveIsUnderAttack(q.queens, row, col); got != want {
+					t.Errorf("size %d: IsUnderAttack(%d, %d) = %v, want %v", size, row, col, got, want)
+				}
+				if got, want := q.HasQueen(row, col), naiveHasQueen(q.queens, row, col); got != want {
+					t.Errorf("size %d: HasQueen(%d, %d) = %v, want %v", size, row, col, got, want)
+				}
+			}
+		}
+
+		for _, queen := range q.queens {
+			others := make([]Position, 0, len(q.queens))
+			for _, other := range q.queens {
+				if other != queen {
+					others = append(others, other)
+				}
+			}
+			if got, want := q.IsQueenUnderAttack(queen.Row, queen.Col), naiveIsUnderAttack(others, queen.Row, queen.Col); got != want {
+				t.Errorf("size %d: IsQueenUnderAttack(%d, %d) = %v, want %v", size, queen.Row, queen.Col, got, want)
+			}
+		}
+	}
+}
+
+// benchmarkQueens runs probe on boards of several sizes, each holding a
+// solution with its last queen removed.
+func benchmarkQueens(b *testing.B, probe func(q Queens)) {
+	for _, size := range []int{8, 14, 20} {
+		solution := NewSolver(size).Solutions(1)[0]
+		q := NewQueensFrom(size, solution[:size-1])
+
+		b.Run(fmt.Sprintf("N=%d", size), func(b *testing.B) {
+			for b.Loop() {
+				probe(q)
+			}
+		})
+	}
+}
+
+func BenchmarkIsUnderAttack(b *testing.B) {
+	benchmarkQueens(b, func(q Queens) {
+		for row := 0; row < q.Size(); row++ {
+			for col := 0; col < q.Size(); col++ {
+				q.IsUnderAttack(row, col)
+			}
+		}
+	})
+}
+
+func BenchmarkIsUnderAttackNaive(b *testing.B) {
+	benchmarkQueens(b, func(q Queens) {
+		for row := 0; row < q.Size(); row++ {
+			for col := 0; col < q.Size(); col++ {
+				naiveIsUnderAttack(q.queens, row, col)
+			}
+		}
+	})
+}
+
+func BenchmarkIsSolved(b *testing.B) {
+	benchmarkQueens(b, func(q Queens) {
+		q.IsSolved()
+	})
+}
+
+func BenchmarkIsSolvedNaive(b *testing.B) {
+	benchmarkQueens(b, func(q Queens) {
+		for i, queen := range q.queens {
+			others := append(append([]Position(nil), q.queens[:i]...), q.queens[i+1:]...)
+			naiveIsUnderAttack(others, queen.Row, queen.Col)
+		}
+	})
+}
+
+func BenchmarkAttackedCells(b *testing.B) {
+	benchmarkQueens(b, func(q Queens) {
+		for row := 0; row < q.Size(); row++ {
+			for col := 0; col < q.Size(); col++ {
+				_ = q.HasQueen(row, col) || q.IsUnderAttack(row, col)
+			}
+		}
+	})
+}
+
+func BenchmarkAttackedCellsNaive(b *testing.B) {
+	benchmarkQueens(b, func(q Queens) {
+		attacked := naiveAttackedPositions(q.queens, q.Size())
+		for row := 0; row < q.Size(); row++ {
+			for col := 0; col < q.Size(); col++ {
+				_ = naiveHasQueen(q.queens, row, col) || attacked[Position{Row: row, Col: col}]
+			}
+		}
+	})
+}
+
+func TestQueensUndoRedo(t *testing.T) {
+	q := NewQueens(DefaultBoardSize)
+
+	if err := q.Undo(); err != ErrNothingToUndo {
+		t.Errorf("Expected ErrNothingToUndo on a fresh board, got %v", err)
+	}
+
+	q.PlaceQueen(0, 0)
+	q.PlaceQueen(1, 2)
+	q.RemoveQueen(0, 0)
+	q.Reset()
+
+	if q.Count() != 0 {
+		t.Fatalf("Expected 0 queens after reset, got %d", q.Count())
+	}
+
+	// Undo the reset, the removal and the second placement.
+	for i := 0; i < 3; i++ {
+		if err := q.Undo(); err != nil {
+			t.Fatalf("Undo %d failed: %v", i+1, err)
+		}
+	}
+
+	if q.Count() != 1 || !q.HasQueen(0, 0) || q.HasQueen(1, 2) {
+		t.Errorf("Expected only the queen at (0, 0) after undoing, got %v", q.queens)
+	}
+
+	if q.IsUnderAttack(1, 2) || !q.IsUnderAttack(1, 1) {
+		t.Errorf("Attack masks were not restored by undo")
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := q.Redo(); err != nil {
+			t.Fatalf("Redo %d failed: %v", i+1, err)
+		}
+	}
+
+	if q.Count() != 0 {
+		t.Errorf("Expected 0 queens after redoing the reset, got %d", q.Count())
+	}
+
+	if err := q.Redo(); err != ErrNothingToRedo {
+		t.Errorf("Expected ErrNothingToRedo, got %v", err)
+	}
+
+	q.Undo()
+	q.PlaceQueen(7, 7)
+	if q.CanRedo() {
+		t.Errorf("A new move should discard the undone moves")
+	}
+}
+
+func TestHint(t *testing.T) {
+	q := NewQueens(DefaultBoardSize)
+	q.PlaceQueen(0, 0)
+
+	hint, err := Hint(&q, 5)
+	if err != nil {
+		t.Fatalf("Expected a hint, got %v", err)
+	}
+
+	if q.IsUnderAttack(hint.Row, hint.Col) {
+		t.Errorf("Hint %v is under attack", hint)
+	}
+
+	q.PlaceQueen(hint.Row, hint.Col)
+	solver, err := NewSolverFrom(&q)
+	if err != nil {
+		t.Fatalf("Failed to create solver: %v", err)
+	}
+	if solver.Count(1) == 0 {
+		t.Errorf("Placing the hint at %v left no solution", hint)
+	}
+
+	// None of the remaining rows can be completed from this placement.
+	dead := NewQueens(DefaultBoardSize)
+	dead.PlaceQueen(0, 0)
+	dead.PlaceQueen(1, 2)
+	dead.PlaceQueen(2, 4)
+	dead.PlaceQueen(3, 1)
+	dead.PlaceQueen(4, 3)
+	if _, err := Hint(&dead, 0); err != ErrUnsolvable {
+		t.Errorf("Expected ErrUnsolvable, got %v", err)
+	}
+}
+
+func TestVariantsCoverAllSolutions(t *testing.T) {
+	fundamentals := GenerateFundamentalSolutions(DefaultBoardSize, nil)
+
+	seen := make(map[string]bool)
+	for _, fundamental := range fundamentals {
+		symmetries := NewSymmetries(fundamental, DefaultBoardSize)
+		for _, variant := range symmetries.Variants() {
+			key := SolutionKey(variant)
+			if seen[key] {
+				t.Errorf("Solution %s appears under two fundamentals", key)
+			}
+			seen[key] = true
+		}
+	}
+
+	if len(seen) != 92 {
+		t.Errorf("Expected 92 distinct solutions, got %d", len(seen))
+	}
+}
+
+func TestSolutionKey(t *testing.T) {
+	content, err := os.ReadFile("boards/s01.txt")
+	if err != nil {
+		t.Fatalf("Failed to read board: %v", err)
+	}
+
+	positions, _ := ParseBoard(string(content))
+	if key := SolutionKey(positions); key != "36271405" {
+		t.Errorf("Expected key 36271405, got %s", key)
+	}
+
+	if key := SolutionKey([]Position{{1, 11}, {0, 19}}); key != "jb" {
+		t.Errorf("Expected key jb, got %s", key)
+	}
+}
+
+func TestPrizeWon(t *testing.T) {
+	fundamental := Prize{Cents: 300, Solutions: 12}
+	distinct := Prize{Cents: 500, Solutions: 92, Distinct: true}
+
+	if !fundamental.Won(12, 12) || fundamental.Won(11, 92) {
+		t.Errorf("Fundamental prize should only count fundamental solutions")
+	}
+
+	if !distinct.Won(0, 92) || distinct.Won(12, 91) {
+		t.Errorf("Distinct prize should only count distinct solutions")
+	}
+}
+
+func TestSolutionIndexMatch(t *testing.T) {
+	fundamentals := GenerateFundamentalSolutions(DefaultBoardSize, nil)
+	index := NewSolutionIndex(fundamentals, DefaultBoardSize)
+
+	for i, fundamental := range fundamentals {
+		for transform := TransformIdentity; transform <= TransformMirrorAD; transform++ {
+			symmetries := NewSymmetries(fundamental, DefaultBoardSize)
+			board := symmetries.transforms[transform]
+
+			matchNum, transforms := index.Match(board)
+			if matchNum != i+1 {
+				t.Fatalf("Expected fundamental %d, got %d", i+1, matchNum)
+			}
+			if !slices.Contains(transforms, transform) {
+				t.Errorf("Fundamental %d %s: transform missing from %v", i+1, transform, transforms)
+			}
+			if want := 8 / len(symmetries.Variants()); len(transforms) != want {
+				t.Errorf("Fundamental %d: expected %d matching transforms, got %d", i+1, want, len(transforms))
+			}
+		}
+	}
+}
+
+func TestTransformJSON(t *testing.T) {
+	transforms := []Transform{TransformIdentity, TransformRot90, TransformMirrorAD}
+
+	data, err := json.Marshal(transforms)
+	if err != nil {
+		t.Fatalf("Failed to marshal transforms: %v", err)
+	}
+	if string(data) != `["identity","rot90","mirror-ad"]` {
+		t.Errorf("Unexpected JSON: %s", data)
+	}
+
+	var decoded []Transform
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal transforms: %v", err)
+	}
+	if !slices.Equal(decoded, transforms) {
+		t.Errorf("Expected %v, got %v", transforms, decoded)
+	}
+}
+
+func TestStabilizerAndOrbitSize(t *testing.T) {
+	boardsFS, err := BoardsFS("")
+	if err != nil {
+		t.Fatalf("Failed to open embedded boards: %v", err)
+	}
+	boards, err := LoadFundamentalSolutions(boardsFS, DefaultBoardSize)
+	if err != nil {
+		t.Fatalf("Failed to load embedded boards: %v", err)
+	}
+	fundamentals := GenerateFundamentalSolutions(DefaultBoardSize, boards)
+
+	orbits := OrbitSizes(fundamentals, DefaultBoardSize)
+	for i, orbit := range orbits {
+		symmetries := NewSymmetries(fundamentals[i], DefaultBoardSize)
+		stabilizer := symmetries.Stabilizer()
+
+		if i == 11 {
+			// s12 is unchanged by a half turn.
+			if !slices.Equal(stabilizer, []Transform{TransformIdentity, TransformRot180}) || orbit != 4 {
+				t.Errorf("s12: expected stabilizer {identity, rot180} and orbit 4, got %v and %d", stabilizer, orbit)
+			}
+		} else if len(stabilizer) != 1 || orbit != 8 {
+			t.Errorf("s%02d: expected trivial stabilizer and orbit 8, got %v and %d", i+1, stabilizer, orbit)
+		}
+	}
+
+	if sum := FormatOrbitSum(orbits); sum != "11×8 + 1×4* = 92" {
+		t.Errorf("Unexpected orbit sum %q", sum)
+	}
+
+	if sum := FormatOrbitSum(OrbitSizes(GenerateFundamentalSolutions(4, nil), 4)); sum != "1×2* = 2" {
+		t.Errorf("Unexpected orbit sum for size 4: %q", sum)
+	}
+}
+
+func TestCanonicalKey(t *testing.T) {
+	for _, size := range []int{5, 8, 11} {
+		for _, fundamental := range GenerateFundamentalSolutions(size, nil) {
+			canonical := CanonicalKey(fundamental, size)
+			if _, classic := classicFundamentals[size]; !classic && canonical != SolutionKey(fundamental) {
+				t.Errorf("size %d: generated fundamental %s is not in canonical form %s", size, SolutionKey(fundamental), canonical)
+			}
+
+			symmetries := NewSymmetries(fundamental, size)
+			for _, variant := range symmetries.Variants() {
+				if key := CanonicalKey(variant, size); key != canonical {
+					t.Errorf("size %d: variant %s has canonical key %s, expected %s", size, SolutionKey(variant), key, canonical)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkSolutionIndexFind(b *testing.B) {
+	const size = 12
+	index := NewSolutionIndex(GenerateFundamentalSolutions(size, nil), size)
+	solution := NewSolver(size).Solutions(1)[0]
+
+	for b.Loop() {
+		index.Find(solution)
+	}
+}
+
+func TestParseSolutionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		line    int
+		err     error
+	}{
+		{"empty", "\n", 0, ErrEmptyBoard},
+		{"too small", "Q..\n..Q\n.Q.\n", 0, ErrBoardSize},
+		{"short row", ".Q..\n...Q\nQ..\n..Q.\n", 3, ErrRowLength},
+		{"bad cell", ".Q..\n...Q\nQ...\n..X.\n", 4, ErrBadCell},
+		{"two queens", ".Q..\n.Q.Q\nQ...\n..Q.\n", 2, ErrTwoQueensInRow},
+		{"no queen", ".Q..\n....\nQ...\n..Q.\n", 2, ErrNoQueenInRow},
+		{"attacked", "Q...\n...Q\n.Q..\n..Q.\n", 4, ErrNotASolution},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSolution(tt.content)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected %v, got %v", tt.err, err)
+			}
+
+			var boardErr *BoardError
+			if !errors.As(err, &boardErr) || boardErr.Line != tt.line {
+				t.Errorf("Expected error on line %d, got %v", tt.line, err)
+			}
+		})
+	}
+
+	positions, err := ParseSolution(".Q..\n...Q\nQ...\n..Q.\n")
+	if err != nil || len(positions) != 4 {
+		t.Errorf("Expected a valid 4x4 solution, got %v, %v", positions, err)
+	}
+}
+
+func TestValidateBoards(t *testing.T) {
+	s01, err := os.ReadFile("boards/s01.txt")
+	if err != nil {
+		t.Fatalf("Failed to read board: %v", err)
+	}
+
+	positions, _ := ParseBoard(string(s01))
+	symmetries := NewSymmetries(positions, DefaultBoardSize)
+	rotated := FormatBoard(symmetries.transforms[TransformRot90], DefaultBoardSize)
+
+	fsys := fstest.MapFS{
+		"a.txt": {Data: s01},
+		"b.txt": {Data: []byte(rotated)},
+		"c.txt": {Data: []byte(".Q..\n...Q\nQ...\n..Q.\n")},
+		"d.txt": {Data: []byte(".Q..\n.Q.Q\nQ...\n..Q.\n")},
+	}
+
+	valid, errs := ValidateBoards(fsys)
+	if valid != 2 || len(errs) != 2 {
+		t.Fatalf("Expected 2 valid boards and 2 errors, got %d and %v", valid, errs)
+	}
+
+	if !errors.Is(errs[0], ErrDuplicateFundamental) || errs[0].Error() != "b.txt: duplicate fundamental solution: same as a.txt" {
+		t.Errorf("Unexpected duplicate error: %v", errs[0])
+	}
+
+	if errs[1].Error() != "d.txt:2: two queens in one row" {
+		t.Errorf("Unexpected row error: %v", errs[1])
+	}
+
+	if _, err := LoadFundamentalSolutions(fsys, 4); !errors.Is(err, ErrDuplicateFundamental) {
+		t.Errorf("LoadFundamentalSolutions should reject the directory, got %v", err)
+	}
+}
+
+func TestBoardFormats(t *testing.T) {
+	queens, err := ParsePermutation("46827135")
+	if err != nil {
+		t.Fatalf("Failed to parse permutation: %v", err)
+	}
+	if !queens.IsSolved() || !queens.HasQueen(0, 2) || !queens.HasQueen(7, 5) {
+		t.Fatalf("Unexpected board:\n%s", queens.Grid())
+	}
+
+	if got := queens.Algebraic(); got != "a4 b6 c8 d2 e7 f1 g3 h5" {
+		t.Errorf("Unexpected algebraic squares %q", got)
+	}
+	if got := queens.FEN(); got != "2Q5/4Q3/1Q6/7Q/Q7/6Q1/3Q4/5Q2" {
+		t.Errorf("Unexpected placement string %q", got)
+	}
+
+	for _, format := range boardFormats {
+		decoded, err := format.Decode(format.Encode(queens), queens.Size())
+		if err != nil {
+			t.Errorf("%s: failed to decode: %v", format.Name, err)
+			continue
+		}
+		if SolutionKey(decoded.queens) != SolutionKey(queens.queens) {
+			t.Errorf("%s: round trip changed the board to\n%s", format.Name, decoded.Grid())
+		}
+		if detected := DetectBoardFormat(format.Encode(queens)); format.Name != "grid" && detected.Name != format.Name {
+			t.Errorf("%s: detected as %s", format.Name, detected.Name)
+		}
+	}
+
+	partial := NewQueensFrom(12, []Position{{Row: 0, Col: 11}, {Row: 11, Col: 0}})
+	if got := partial.Permutation(); got != "1,.,.,.,.,.,.,.,.,.,.,12" {
+		t.Errorf("Unexpected permutation %q", got)
+	}
+	for _, format := range boardFormats {
+		decoded, err := format.Decode(format.Encode(partial), 12)
+		if err != nil || decoded.Size() != 12 || decoded.Count() != 2 || !decoded.HasQueen(0, 11) {
+			t.Errorf("%s: partial round trip gave %v:\n%s", format.Name, err, decoded.Grid())
+		}
+	}
+
+	bad := []struct {
+		format string
+		input  string
+		err    error
+	}{
+		{"perm", "4682713", ErrBadPermutation},
+		{"perm", "46827139", ErrBadPermutation},
+		{"perm", "123", ErrBadPermutation},
+		{"perm", "1,2,x,4", ErrBadPermutation},
+		{"fen", "8/8/8/8/8/8/8/7", ErrBadFEN},
+		{"fen", "8/8/8/8/8/8/8/7X", ErrBadFEN},
+		{"fen", "8/8/8", ErrBadFEN},
+		{"algebraic", "a4 i1", ErrBadSquare},
+		{"algebraic", "a0", ErrBadSquare},
+		{"algebraic", "4a", ErrBadSquare},
+		{"grid", "....\n....\n..Q\n....\n", ErrBadGrid},
+		{"grid", "....\n.X..\n....\n....\n", ErrBadGrid},
+		{"grid", "..\n..\n", ErrBadGrid},
+		{"json", `{"size":30,"queens":[]}`, ErrBadJSON},
+		{"json", `{"size":8,"queens":["z9"]}`, ErrBadSquare},
+		{"json", `{"size":8,`, ErrBadJSON},
+	}
+	for _, tt := range bad {
+		format, _ := GetBoardFormat(tt.format)
+		_, err := format.Decode(tt.input, DefaultBoardSize)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s %q: expected %v, got %v", tt.format, tt.input, tt.err, err)
+		}
+	}
+
+	_, err = ParseJSON(`{"size":30,"queens":[]}`)
+	if err == nil || strings.Contains(err.Error(), "-size") {
+		t.Errorf("JSON size error should not name the command-line flag, got %v", err)
+	}
+}
+
+func TestLoadUndo(t *testing.T) {
+	queens := NewQueens(DefaultBoardSize)
+	queens.PlaceQueen(0, 0)
+
+	solution, _ := ParsePermutation("46827135")
+	if err := queens.Load(solution.queens); err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if !queens.IsSolved() {
+		t.Fatal("Loaded board should be solved")
+	}
+
+	queens.Undo()
+	if queens.Count() != 1 || !queens.HasQueen(0, 0) {
+		t.Errorf("Undo should restore the previous board, got\n%s", queens.Grid())
+	}
+
+	queens.Redo()
+	if !queens.IsSolved() {
+		t.Errorf("Redo should load the board again, got\n%s", queens.Grid())
+	}
+
+	if err := queens.Load([]Position{{Row: 8, Col: 0}}); err != ErrOutOfBounds {
+		t.Errorf("Expected ErrOutOfBounds, got %v", err)
+	}
+}
+
+func TestSaveRoundTrip(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	queens := NewQueens(DefaultBoardSize)
+	queens.PlaceQueenUnchecked(0, 0)
+	queens.PlaceQueenUnchecked(1, 1)
+	queens.PlaceQueenUnchecked(2, 5)
+	queens.Undo()
+	queens.SetSymbol(SymbolWhite)
+
+	save := SavedGame{Board: queens, History: queens.History(), CursorRow: 3, CursorCol: 4, Symbol: queens.Symbol(), Hard: true}
+	if err := WriteSave("alice", "attempt-1", save); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	loaded, err := ReadSave("alice", "attempt-1")
+	if err != nil {
+		t.Fatalf("Failed to read save: %v", err)
+	}
+
+	restored := loaded.Queens()
+	if restored.Count() != 2 || !restored.HasQueen(1, 1) || restored.Symbol() != SymbolWhite {
+		t.Errorf("Unexpected restored board:\n%s", restored.Grid())
+	}
+	if loaded.CursorRow != 3 || loaded.CursorCol != 4 || !loaded.Hard {
+		t.Errorf("Unexpected restored settings: %+v", loaded)
+	}
+
+	if err := restored.Redo(); err != nil || !restored.HasQueen(2, 5) {
+		t.Errorf("Redo after restoring should place the undone queen, got %v", err)
+	}
+	restored.Undo()
+	restored.Undo()
+	if restored.Count() != 1 {
+		t.Errorf("Expected 1 queen after undoing twice, got %d", restored.Count())
+	}
+
+	if _, err := ReadSave("bob", "attempt-1"); !errors.Is(err, ErrNoSave) {
+		t.Errorf("Saves should be kept per player, got %v", err)
+	}
+	for _, name := range []string{"../escape", ".autosave-8", "a/b", ""} {
+		if err := WriteSave("alice", name, save); !errors.Is(err, ErrBadSaveName) {
+			t.Errorf("%q: expected ErrBadSaveName, got %v", name, err)
+		}
+	}
+	for _, player := range []string{"../bob", ".hidden", `a\b`, ".."} {
+		if err := WriteSave(player, "attempt-1", save); !errors.Is(err, ErrBadPlayerName) {
+			t.Errorf("%q: expected ErrBadPlayerName, got %v", player, err)
+		}
+	}
+
+	if err := WriteAutosave("alice", save); err != nil {
+		t.Fatalf("Failed to autosave: %v", err)
+	}
+	if _, err := ReadAutosave("alice", 10); !errors.Is(err, ErrNoSave) {
+		t.Errorf("Autosaves should be kept per board size, got %v", err)
+	}
+	if autosave, err := ReadAutosave("alice", DefaultBoardSize); err != nil || autosave.Board.Count() != 2 {
+		t.Errorf("Unexpected autosave %+v, %v", autosave, err)
+	}
+
+	// Histories that don't lead to the board are rejected: undoing the
+	// removal of a queen that is still there would place it twice.
+	stale := []History{
+		{Done: []Move{{Kind: MovePlace, Row: 0, Col: 0}, {Kind: MoveRemove, Row: 0, Col: 0}}},
+		{Done: []Move{{Kind: MovePlace, Row: 0, Col: 0}, {Kind: MovePlace, Row: 1, Col: 1}, {Kind: MovePlace, Row: 0, Col: 0}}},
+		{Done: save.History.Done, Undone: []Move{{Kind: MovePlace, Row: 1, Col: 1}}},
+		{Done: []Move{{Kind: MovePlace, Row: 1, Col: 1}, {Kind: MoveReset, Queens: []Position{{Row: 0, Col: 0}}}}},
+	}
+	for i, history := range stale {
+		save.History = history
+		if err := WriteSave("alice", "stale", save); err != nil {
+			t.Fatalf("Failed to save: %v", err)
+		}
+		if _, err := ReadSave("alice", "stale"); !errors.Is(err, ErrBadSave) {
+			t.Errorf("History %d: expected ErrBadSave, got %v", i, err)
+		}
+	}
+
+	g := newTestGame(t, DefaultBoardSize)
+	g.player = "../bob"
+	g.Autosave()
+	if !strings.HasPrefix(g.message, "Autosave failed") {
+		t.Errorf("A failed autosave should be reported, got %q", g.message)
+	}
+
+	// Neither can a bad history corrupt a board in play.
+	queens = NewQueens(DefaultBoardSize)
+	queens.PlaceQueen(0, 0)
+	queens.SetHistory(History{Done: []Move{{Kind: MoveRemove, Row: 0, Col: 0}}})
+	if err := queens.Undo(); !errors.Is(err, ErrBadHistory) || queens.Count() != 1 || !queens.CanUndo() {
+		t.Errorf("Expected ErrBadHistory and no change, got %v with %d queens", err, queens.Count())
+	}
+}
+
+func newTestGame(t *testing.T, size int) *Game {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+
+	fundamentals, err := LoadFundamentals("", size)
+	if err != nil {
+		t.Fatalf("Failed to load fundamentals: %v", err)
+	}
+	config := &Config{Players: map[string]PlayerData{"alice": {}}}
+	keymap, _ := NewKeymap("default", KeyFile{})
+	return NewGame(&Terminal{keymap: keymap}, &recordingRenderer{}, "", config, "alice", size, fundamentals, nil, false, false, nil)
+}
+
+func TestGameCommands(t *testing.T) {
+	g := newTestGame(t, DefaultBoardSize)
+
+	for _, line := range []string{":place e4", ":goto c6", ":symbol white"} {
+		if g.execute(line) || g.commandError != "" {
+			t.Fatalf("%s failed: %s", line, g.commandError)
+		}
+	}
+	if !g.queens.HasQueen(4, 4) || g.cursorRow != 2 || g.cursorCol != 2 || g.queens.Symbol() != SymbolWhite {
+		t.Errorf("Unexpected state after commands: cursor %d,%d\n%s", g.cursorRow, g.cursorCol, g.queens.Grid())
+	}
+
+	bad := []struct {
+		line string
+		err  error
+	}{
+		{":place e5", ErrUnderAttack},
+		{":place z9", ErrBadSquare},
+		{":remove a1", ErrNoQueenToRemove},
+		{":goto", ErrUsage},
+		{":place a1 b2", ErrUsage},
+		{":frobnicate", ErrUnknownCommand},
+		{":symbol fancy", nil},
+		{":size 30", nil},
+		{":load 4682713", nil},
+		{":load 12......", ErrInvalidPartial},
+		{":help frobnicate", ErrUnknownCommand},
+	}
+	for _, tt := range bad {
+		line, want := tt.line, tt.err
+		command, args, err := parseCommandLine(line)
+		if err == nil {
+			err = command.run(g, args)
+		}
+		if err == nil || (want != nil && !errors.Is(err, want)) {
+			t.Errorf("%s: expected %v, got %v", line, want, err)
+		}
+		if g.execute(line) || g.commandError == "" {
+			t.Errorf("%s: expected an error on the command line", line)
+		}
+	}
+
+	if g.execute(":remove e4"); g.queens.Count() != 0 {
+		t.Errorf("Expected an empty board, got\n%s", g.queens.Grid())
+	}
+
+	if g.execute(":solve"); !g.queens.IsSolved() || len(g.found()) != 0 {
+		t.Errorf("Solve should fill in the board without recording it, found %v", g.found())
+	}
+	g.queens.Undo()
+	if g.queens.Count() != 0 {
+		t.Errorf("Undo should take back the solve, got\n%s", g.queens.Grid())
+	}
+
+	g.execute(":place a1")
+	if g.execute(":size 6"); g.queens.Size() != 6 || g.queens.Count() != 0 || len(g.fundamentals) != 1 {
+		t.Errorf("Expected an empty 6x6 board, got %d fundamentals\n%s", len(g.fundamentals), g.queens.Grid())
+	}
+	if g.execute(":size 8"); !g.queens.HasQueen(7, 0) {
+		t.Errorf("Switching back should restore the 8x8 game, got\n%s", g.queens.Grid())
+	}
+
+	if !g.execute(":q") {
+		t.Error(":q should exit")
+	}
+}
+
+func TestSolveDoesNotCount(t *testing.T) {
+	g := newTestGame(t, 6)
+	g.execute(":solve")
+	solution := slices.Clone(g.queens.queens)
+
+	g.Handle(NewCmd(CodeUndo))
+	g.Handle(NewCmd(CodeRedo))
+	if !g.queens.IsSolved() || len(g.found()) != 0 {
+		t.Errorf("Undo and redo should not record the solver's board, found %v", g.found())
+	}
+
+	g.cursorRow, g.cursorCol = solution[0].Row, solution[0].Col
+	g.Handle(NewCmd(CodePlace))
+	g.Handle(NewCmd(CodePlace))
+	if !g.queens.IsSolved() || len(g.found()) != 0 || g.message != solvedForYou {
+		t.Errorf("Re-placing a queen should not record the solver's board, found %v, message %q", g.found(), g.message)
+	}
+
+	// The mirror image puts no queen where the solver did.
+	g.Handle(NewCmd(CodeReset))
+	for _, pos := range solution {
+		g.cursorRow, g.cursorCol = pos.Row, 5-pos.Col
+		g.Handle(NewCmd(CodePlace))
+	}
+	if !g.queens.IsSolved() || len(g.found()) != 1 {
+		t.Errorf("A board the player solved should be recorded, found %v\n%s", g.found(), g.queens.Grid())
+	}
+}
+
+func TestLoadDoesNotCount(t *testing.T) {
+	g := newTestGame(t, DefaultBoardSize)
+	if g.execute(":load 46827135"); !g.queens.IsSolved() || len(g.found()) != 0 || countSolved(g.solved()) != 0 {
+		t.Errorf("A loaded solution should not be recorded, found %v, solved %v", g.found(), g.solved())
+	}
+	if g.message != solvedForYou {
+		t.Errorf("Expected %q, got %q", solvedForYou, g.message)
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	line := NewLineEditor([]string{"place e4", "goto c6"})
+	for _, r := range "plce a1" {
+		line.Insert(r)
+	}
+
+	for range 5 {
+		line.Left()
+	}
+	line.Insert('a')
+	if line.String() != "place a1" || line.Cursor() != 3 {
+		t.Errorf("Expected \"place a1\" with the cursor at 3, got %q at %d", line.String(), line.Cursor())
+	}
+
+	for range 10 {
+		line.Right()
+	}
+	line.Backspace()
+	line.Insert('2')
+	if line.String() != "place a2" {
+		t.Errorf("Expected \"place a2\", got %q", line.String())
+	}
+
+	line.Previous()
+	if line.String() != "goto c6" {
+		t.Errorf("Expected the newest history line, got %q", line.String())
+	}
+	line.Previous()
+	line.Previous()
+	if line.String() != "place e4" {
+		t.Errorf("Expected the oldest history line, got %q", line.String())
+	}
+	line.Next()
+	line.Next()
+	if line.String() != "place a2" {
+		t.Errorf("Expected the draft back, got %q", line.String())
+	}
+
+	line.DeleteWord()
+	if line.String() != "place " {
+		t.Errorf("Ctrl-W should delete the last word, got %q", line.String())
+	}
+	line.DeleteWord()
+	if line.String() != "" {
+		t.Errorf("Ctrl-W should delete the word and its spaces, got %q", line.String())
+	}
+
+	for _, r := range "goto b3" {
+		line.Insert(r)
+	}
+	line.Left()
+	line.Left()
+	line.DeleteToStart()
+	if line.String() != "b3" || line.Cursor() != 0 {
+		t.Errorf("Ctrl-U should delete up to the cursor, got %q at %d", line.String(), line.Cursor())
+	}
+
+	line.AddHistory("goto b3")
+	line.AddHistory("goto b3")
+	line.AddHistory("  ")
+	if history := line.History(); len(history) != 3 || history[2] != "goto b3" {
+		t.Errorf("Unexpected history %v", history)
+	}
+}
+
+func TestCommandCompletion(t *testing.T) {
+	g := newTestGame(t, DefaultBoardSize)
+	g.execute(":place a1")
+	g.execute(":w first")
+	g.execute(":w second")
+
+	complete := func(typed string) (string, string) {
+		g.message = ""
+		g.commandLine.Reset()
+		for _, r := range typed {
+			g.commandLine.Insert(r)
+		}
+		g.completeCommand()
+		return g.commandLine.String(), g.message
+	}
+
+	tests := []struct {
+		typed   string
+		line    string
+		message string
+	}{
+		{"pl", "place ", ""},
+		{"s", "s", "size  symbol  solve  stats"},
+		{"sy", "symbol ", ""},
+		{"symbol w", "symbol white ", ""},
+		{"remove ", "remove a1 ", ""},
+		{"e ", "e ", "first  second"},
+		{"e f", "e first ", ""},
+		{"place a", "place a", ""},
+		{"place b", "place b", "b3  b4  b5  b6  b7  b8"},
+		{"size 1", "size 1", "10  11  12  13  14  15  16  17  18  19"},
+		{"hint ", "hint ", ""},
+		{"nope ", "nope ", ""},
+	}
+	for _, tt := range tests {
+		line, message := complete(tt.typed)
+		if line != tt.line || message != tt.message {
+			t.Errorf("%q: expected %q and %q, got %q and %q", tt.typed, tt.line, tt.message, line, message)
+		}
+	}
+}
+
+func decodeAll(d *KeyDecoder) []KeyEvent {
+	var events []KeyEvent
+	for {
+		event, ok := d.Next(false)
+		if !ok {
+			event, ok = d.Next(true)
+		}
+		if !ok {
+			return events
+		}
+		events = append(events, event)
+	}
+}
+
+func TestKeyDecoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		events []KeyEvent
+	}{
+		{"typing", "ab", []KeyEvent{{Key: KeyRune, Rune: 'a'}, {Key: KeyRune, Rune: 'b'}}},
+		{"utf-8", "é♛", []KeyEvent{{Key: KeyRune, Rune: 'é'}, {Key: KeyRune, Rune: '♛'}}},
+		{"controls", "\r\t\x7f\x12", []KeyEvent{{Key: KeyEnter}, {Key: KeyTab}, {Key: KeyBackspace}, {Key: KeyRune, Rune: 'r', Mod: ModCtrl}}},
+		{"csi arrows", "\x1b[A\x1b[D", []KeyEvent{{Key: KeyUp}, {Key: KeyLeft}}},
+		{"ss3 arrows", "\x1bOB\x1bOC", []KeyEvent{{Key: KeyDown}, {Key: KeyRight}}},
+		{"home and page up", "\x1b[1~\x1b[5~\x1b[F", []KeyEvent{{Key: KeyHome}, {Key: KeyPageUp}, {Key: KeyEnd}}},
+		{"modifiers", "\x1b[1;5C\x1b[3;2~", []KeyEvent{{Key: KeyRight, Mod: ModCtrl}, {Key: KeyDelete, Mod: ModShift}}},
+		{"alt", "\x1bx", []KeyEvent{{Key: KeyRune, Rune: 'x', Mod: ModAlt}}},
+		{"esc then arrow", "\x1b\x1b[A", []KeyEvent{{Key: KeyEsc}, {Key: KeyUp}}},
+		{"lone esc", "\x1b", []KeyEvent{{Key: KeyEsc}}},
+		{"unknown csi", "\x1b[99zq", []KeyEvent{{Key: KeyUnknown}, {Key: KeyRune, Rune: 'q'}}},
+		{"broken csi", "\x1b[1\x01", []KeyEvent{{Key: KeyUnknown}, {Key: KeyRune, Rune: 'a', Mod: ModCtrl}}},
+		{"mouse click", "\x1b[<0;12;7M\x1b[<0;12;7m", []KeyEvent{
+			{Key: KeyMouse, Mouse: Mouse{X: 12, Y: 7, Button: MouseLeft}},
+			{Key: KeyMouse, Mouse: Mouse{X: 12, Y: 7, Button: MouseLeft, Release: true}},
+		}},
+		{"mouse motion", "\x1b[<35;140;2M", []KeyEvent{{Key: KeyMouse, Mouse: Mouse{X: 140, Y: 2, Button: MouseNone, Motion: true}}}},
+		{"mouse wheel", "\x1b[<81;1;1M", []KeyEvent{{Key: KeyMouse, Mod: ModCtrl, Mouse: Mouse{X: 1, Y: 1, Button: MouseWheelDown}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d KeyDecoder
+			d.Feed([]byte(tt.input))
+			if events := decodeAll(&d); !slices.Equal(events, tt.events) {
+				t.Errorf("Expected %v, got %v", tt.events, events)
+			}
+		})
+	}
+
+	var d KeyDecoder
+	d.Feed([]byte("\x1b["))
+	if _, ok := d.Next(false); ok {
+		t.Fatal("An unfinished sequence should wait for more input")
+	}
+	d.Feed([]byte("B"))
+	if event, ok := d.Next(false); !ok || event.Key != KeyDown {
+		t.Errorf("Expected KeyDown once the sequence is complete, got %v", event)
+	}
+}
+
+// FuzzKeyDecoder checks that any input decodes without getting stuck, and
+// that splitting it between reads doesn't change the keys.
+func FuzzKeyDecoder(f *testing.F) {
+	f.Add([]byte("\x1b[1;5A\x1bOBq\x1b"), 3)
+	f.Add([]byte("é\x1b\x1b[5~\x1b[<0;3;4M"), 1)
+	f.Add([]byte("\x1b[111111111111111111111111111111111111~"), 20)
+
+	f.Fuzz(func(t *testing.T, input []byte, split int) {
+		var whole KeyDecoder
+		whole.Feed(input)
+		expected := decodeAll(&whole)
+		if whole.Pending() {
+			t.Fatalf("Input left over after flushing: %q", whole.buf)
+		}
+
+		split = min(max(split, 0), len(input))
+		var parts KeyDecoder
+		parts.Feed(input[:split])
+		var events []KeyEvent
+		for {
+			event, ok := parts.Next(false)
+			if !ok {
+				break
+			}
+			events = append(events, event)
+		}
+		parts.Feed(input[split:])
+		events = append(events, decodeAll(&parts)...)
+
+		if !slices.Equal(events, expected) {
+			t.Errorf("Split at %d: expected %v, got %v", split, expected, events)
+		}
+	})
+}
+
+func TestMouseCells(t *testing.T) {
+	g := newTestGame(t, DefaultBoardSize)
+	g.boardX, g.boardY = 10, 5
+
+	tests := []struct {
+		x, y int
+		pos  Position
+		ok   bool
+	}{
+		{11, 6, Position{Row: 0, Col: 0}, true},
+		{13, 6, Position{Row: 0, Col: 0}, true},
+		{14, 6, Position{}, false},
+		{15, 8, Position{Row: 1, Col: 1}, true},
+		{41, 20, Position{Row: 7, Col: 7}, true},
+		{42, 20, Position{}, false},
+		{11, 7, Position{}, false},
+		{9, 6, Position{}, false},
+		{11, 22, Position{}, false},
+	}
+	for _, tt := range tests {
+		cmd := mouseCommand(Mouse{X: tt.x, Y: tt.y, Button: MouseLeft})
+		if cmd.Code != CodeClick {
+			t.Fatalf("A left press should be a click, got %v", cmd.Code)
+		}
+		pos, ok := g.cellAt(cmd.Data)
+		if pos != tt.pos || ok != tt.ok {
+			t.Errorf("(%d, %d): expected %v %v, got %v %v", tt.x, tt.y, tt.pos, tt.ok, pos, ok)
+		}
+	}
+
+	if cmd := mouseCommand(Mouse{Button: MouseLeft, Release: true}); cmd.Code != CodeNone {
+		t.Errorf("A release should do nothing, got %v", cmd.Code)
+	}
+	if cmd := mouseCommand(Mouse{Button: MouseNone, Motion: true}); cmd.Code != CodeHover {
+		t.Errorf("Moving with no button should hover, got %v", cmd.Code)
+	}
+}
+
+func TestReadInputEOF(t *testing.T) {
+	keymap, _ := NewKeymap("default", KeyFile{})
+	input := make(chan inputChunk, 1)
+	input <- inputChunk{data: []byte("u"), err: io.EOF}
+	terminal := &Terminal{keymap: keymap, input: input}
+
+	if cmd, err := terminal.ReadInput(); err != nil || cmd.Code != CodeUndo {
+		t.Errorf("Expected the key read before EOF, got %v, %v", cmd.Code, err)
+	}
+	// Nothing is sent again, so a call waiting for input would block.
+	for range 2 {
+		if _, err := terminal.ReadInput(); err != io.EOF {
+			t.Errorf("Expected EOF on every call after it, got %v", err)
+		}
+	}
+}
+
+func TestKeymap(t *testing.T) {
+	for _, preset := range KeyPresetNames() {
+		keymap, errs := NewKeymap(preset, KeyFile{})
+		if len(errs) > 0 {
+			t.Errorf("%s: %v", preset, errs)
+		}
+
+		// Each preset binds every action the controls show.
+		for _, row := range controlsRows {
+			if controlsKeys(keymap, row.codes) == "" {
+				t.Errorf("%s: no keys for %q", preset, row.label)
+			}
+		}
+	}
+
+	vim, _ := NewKeymap("vim", KeyFile{})
+	terminal := Terminal{keymap: vim}
+	tests := []struct {
+		event KeyEvent
+		code  Code
+	}{
+		{KeyEvent{Key: KeyRune, Rune: 'h'}, CodeLeft},
+		{KeyEvent{Key: KeyRune, Rune: 'q'}, CodeExit},
+		{KeyEvent{Key: KeyRune, Rune: 'r', Mod: ModCtrl}, CodeRedo},
+		{KeyEvent{Key: KeyUp, Mod: ModShift}, CodeUp},
+		{KeyEvent{Key: KeyRune, Rune: 'z'}, CodeNone},
+		{KeyEvent{Key: KeyRune, Rune: 'h', Mod: ModAlt}, CodeNone},
+	}
+	for _, tt := range tests {
+		if cmd := terminal.command(tt.event); cmd.Code != tt.code {
+			t.Errorf("%v: expected %v, got %v", tt.event, tt.code, cmd.Code)
+		}
+	}
+
+	terminal.noExit = true
+	if cmd := terminal.command(KeyEvent{Key: KeyRune, Rune: 'q'}); cmd.Code != CodeNone {
+		t.Errorf("Exit keys should do nothing with -noexit, got %v", cmd.Code)
+	}
+
+	var keyFile KeyFile
+	err := json.Unmarshal([]byte(`{"preset": "default", "keys": {"p": "place", "q": "exit", "Ctrl-Z": "undo", "ctrl-z": "redo", "F1": "help", "y": "fly", ":": "none"}}`), &keyFile)
+	if err != nil {
+		t.Fatalf("Failed to read key file: %v", err)
+	}
+
+	keymap, errs := NewKeymap("", keyFile)
+	wanted := []error{ErrKeyConflict, ErrUnknownKey, ErrUnknownAction, ErrUnreachable}
+	if len(errs) != len(wanted) {
+		t.Fatalf("Expected %d problems, got %v", len(wanted), errs)
+	}
+	for i, err := range errs {
+		if !errors.Is(err, wanted[i]) {
+			t.Errorf("Expected %v, got %v", wanted[i], err)
+		}
+	}
+	if keymap.Lookup(KeyEvent{Key: KeyRune, Rune: 'p'}) != CodePlace || keymap.Lookup(KeyEvent{Key: KeyRune, Rune: 'q'}) != CodeExit {
+		t.Error("Bindings from the file should replace the preset's")
+	}
+
+	if _, errs := NewKeymap("emacs", KeyFile{}); len(errs) != 1 || !errors.Is(errs[0], ErrUnknownPreset) {
+		t.Errorf("Expected ErrUnknownPreset, got %v", errs)
+	}
+
+	for _, name := range []string{"a", "A", "space", "ctrl-r", "shift-up", "alt-x", "pgdn", "-"} {
+		event, err := ParseKey(name)
+		if err != nil || event.String() != name {
+			t.Errorf("%q: round trip gave %q, %v", name, event.String(), err)
+		}
+	}
+	for _, name := range []string{"shift-a", "shift-A", "shift-space"} {
+		if _, err := ParseKey(name); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("%q: expected ErrUnknownKey, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"ctrl-h", "Ctrl-I", "ctrl-j", "alt-ctrl-m"} {
+		if _, err := ParseKey(name); !errors.Is(err, ErrUnreachable) {
+			t.Errorf("%q: expected ErrUnreachable, got %v", name, err)
+		}
+	}
+	if event, _ := ParseKey("Ctrl-R"); event.String() != "ctrl-r" {
+		t.Errorf("Expected ctrl-r, got %q", event.String())
+	}
+	if got := displayKey("ctrl-r"); got != "Ctrl-R" {
+		t.Errorf("Expected Ctrl-R, got %q", got)
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares output with testdata/<name>.golden, or rewrites the
+// file when the tests are run with -update.
+func checkGolden(t *testing.T, name string, output string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(output), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+		return
+	}
+
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	if output != string(golden) {
+		t.Errorf("Output differs from %s (rerun with -update if intended):\n%s", path, output)
+	}
+}
+
+type drawCall struct {
+	method string
+	data   any
+}
+
+// recordingRenderer keeps the panels of the last screen drawn, with the
+// data each was drawn from.
+type recordingRenderer struct {
+	calls []drawCall
+}
+
+func (r *recordingRenderer) record(method string, data any) {
+	r.calls = append(r.calls, drawCall{method, data})
+}
+
+func (r *recordingRenderer) Begin()                           { r.calls = nil }
+func (r *recordingRenderer) Title(size int, solved bool)      { r.record("Title", size) }
+func (r *recordingRenderer) Board(board BoardView)            { r.record("Board", board) }
+func (r *recordingRenderer) Status(status StatusView)         { r.record("Status", status) }
+func (r *recordingRenderer) DiscoveryGrid(grid DiscoveryView) { r.record("DiscoveryGrid", grid) }
+func (r *recordingRenderer) VariantsGrid(grid VariantsView)   { r.record("VariantsGrid", grid) }
+func (r *recordingRenderer) Prizes(prizes PrizesView)         { r.record("Prizes", prizes) }
+func (r *recordingRenderer) Controls(controls []ControlView)  { r.record("Controls", controls) }
+func (r *recordingRenderer) CommandLine(line CommandLineView) { r.record("CommandLine", line) }
+func (r *recordingRenderer) End() error                       { r.record("End", nil); return nil }
+
+func (r *recordingRenderer) methods() []string {
+	var methods []string
+	for _, call := range r.calls {
+		methods = append(methods, call.method)
+	}
+	return methods
+}
+
+func (r *recordingRenderer) find(method string) any {
+	for _, call := range r.calls {
+		if call.method == method {
+			return call.data
+		}
+	}
+	return nil
+}
+
+func TestDiscoverySummary(t *testing.T) {
+	for _, tt := range []struct {
+		size    int
+		summary bool
+	}{
+		{DefaultBoardSize, false},
+		{10, true},
+		{maxGeneratedSize + 1, false},
+	} {
+		g := newTestGame(t, tt.size)
+		g.render()
+		grid := g.renderer.(*recordingRenderer).find("DiscoveryGrid").(DiscoveryView)
+		if grid.Summary != tt.summary {
+			t.Errorf("size %d: expected summary %v, got %v", tt.size, tt.summary, grid.Summary)
+		}
+	}
+}
+
+func TestRenderScreen(t *testing.T) {
+	g := newTestGame(t, DefaultBoardSize)
+	recorder := g.renderer.(*recordingRenderer)
+
+	g.execute(":place e4")
+	g.render()
+	expected := []string{"Title", "Board", "Status", "DiscoveryGrid", "Prizes", "Controls", "End"}
+	if !slices.Equal(recorder.methods(), expected) {
+		t.Fatalf("Expected %v, got %v", expected, recorder.methods())
+	}
+
+	board := recorder.find("Board").(BoardView)
+	if board.Size != 8 || board.Cells[4][4] != CellQueenCursor || board.Cells[0][0] != CellEmpty {
+		t.Errorf("Unexpected board view %+v", board)
+	}
+	status := recorder.find("Status").(StatusView)
+	if status.Count != 1 || status.Size != 8 || status.Solved || status.Reachable <= 0 {
+		t.Errorf("Unexpected status view %+v", status)
+	}
+	if controls := recorder.find("Controls").([]ControlView); controls[0] != (ControlView{Keys: "Esc", Label: "Exit"}) {
+		t.Errorf("Unexpected controls %v", controls)
+	}
+
+	g.showVariants = true
+	g.showHelp = true
+	g.execute(":frobnicate")
+	g.render()
+	expected = []string{"Title", "Board", "Status", "VariantsGrid", "Prizes", "Controls", "CommandLine", "End"}
+	if !slices.Equal(recorder.methods(), expected) {
+		t.Fatalf("Expected %v, got %v", expected, recorder.methods())
+	}
+	if board := recorder.find("Board").(BoardView); board.Cells[0][0] != CellAttacked || board.Cells[0][1] != CellEmpty {
+		t.Errorf("Help should show attacked cells: %+v", board)
+	}
+	if grid := recorder.find("VariantsGrid").(VariantsView); grid.Total != 92 || len(grid.Variants) != 12 || grid.Found != 0 {
+		t.Errorf("Unexpected variants view %+v", grid)
+	}
+	if line := recorder.find("CommandLine").(CommandLineView); !strings.Contains(line.Error, "frobnicate") {
+		t.Errorf("Unexpected command line %+v", line)
+	}
+
+	g.execute(fmt.Sprintf(":size %d", maxGeneratedSize+1))
+	g.render()
+	if status := recorder.find("Status").(StatusView); !status.Untracked {
+		t.Errorf("Boards above %d should be untracked: %+v", maxGeneratedSize, status)
+	}
+	var output strings.Builder
+	if err := drawScreen(NewPlainRenderer(&output, 160), g); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "Fundamentals aren't tracked above 14x14") {
+		t.Errorf("Expected the status line to say the board isn't tracked:\n%s", output.String())
+	}
+
+	g.execute(":solve")
+	output.Reset()
+	if err := drawScreen(NewPlainRenderer(&output, 160), g); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "15-Queens Puzzle: Solved!") {
+		t.Errorf("Expected the title to say the board is solved:\n%s", output.String())
+	}
+}
+
+func TestRenderGolden(t *testing.T) {
+	g := newTestGame(t, 6)
+	g.prizes = []Prize{
+		{Cents: 10, Solutions: 1, Label: "First solution"},
+		{Cents: 50, Solutions: 4, Distinct: true, Label: "Every solution"},
+	}
+	// The solution is placed queen by queen, since a loaded one doesn't count.
+	for _, line := range []string{":place a2", ":place b4", ":place c6", ":place d1", ":place e3", ":place f5", ":reset", ":place a2", ":goto d5"} {
+		if g.execute(line) || g.commandError != "" {
+			t.Fatalf("%s failed: %s", line, g.commandError)
+		}
+	}
+	g.showHelp = true
+	g.labels = LabelsChess
+	g.showHint()
+	g.setCommandMode(true)
+	for _, r := range "place c" {
+		g.commandLine.Insert(r)
+	}
+
+	renderers := []struct {
+		name     string
+		renderer func(w io.Writer) *TextRenderer
+	}{
+		{"plain", func(w io.Writer) *TextRenderer { return NewPlainRenderer(w, 50) }},
+		{"plain_wide", func(w io.Writer) *TextRenderer { return NewPlainRenderer(w, 100) }},
+		{"ansi", func(w io.Writer) *TextRenderer {
+			r := NewANSIRenderer(w)
+			r.size = func() (int, int) { return 100, 40 }
+			return r
+		}},
+	}
+	for _, tt := range renderers {
+		var output strings.Builder
+		renderer := tt.renderer(&output)
+		if err := drawScreen(renderer, g); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		checkGolden(t, "screen_"+tt.name, output.String())
+
+		// The board's top-left corner is where the renderer says it is.
+		x, y := renderer.BoardOrigin()
+		corner := strings.Repeat(" ", x-1) + "┌"
+		found := false
+		if tt.name == "ansi" {
+			found = strings.Contains(output.String(), fmt.Sprintf("\033[%d;1H%s", y, corner))
+		} else if lines := strings.Split(output.String(), "\n"); y >= 1 && y <= len(lines) {
+			found = strings.HasPrefix(lines[y-1], corner)
+		}
+		if !found {
+			t.Errorf("%s: board not at (%d, %d)", tt.name, x, y)
+		}
+	}
+}
+
+func TestRenderFit(t *testing.T) {
+	g := newTestGame(t, 6)
+	g.prizes = []Prize{{Cents: 10, Solutions: 1, Label: "First solution"}}
+
+	// The full 6x6 screen is 40 rows: the controls go first, then the
+	// prizes, the title and the solutions grid.
+	tests := []struct {
+		height int
+		shown  []string
+		hidden []string
+	}{
+		{0, []string{"Puzzle", "Fundamental", "Prizes:", "Controls:"}, nil},
+		{40, []string{"Puzzle", "Fundamental", "Prizes:", "Controls:"}, nil},
+		{30, []string{"Puzzle", "Fundamental", "Prizes:"}, []string{"Controls:"}},
+		{24, []string{"Puzzle", "Fundamental"}, []string{"Prizes:", "Controls:"}},
+		{15, []string{"Queens: 0/6"}, []string{"Puzzle", "Fundamental", "Prizes:", "Controls:"}},
+	}
+	for _, tt := range tests {
+		var output strings.Builder
+		renderer := NewPlainRenderer(&output, 50)
+		renderer.size = func() (int, int) { return 50, tt.height }
+		if err := drawScreen(renderer, g); err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Count(output.String(), "\n")
+		if tt.height > 0 && lines > tt.height {
+			t.Errorf("Height %d: drew %d lines", tt.height, lines)
+		}
+		for _, text := range tt.shown {
+			if !strings.Contains(output.String(), text) {
+				t.Errorf("Height %d: expected %q to be shown", tt.height, text)
+			}
+		}
+		for _, text := range tt.hidden {
+			if strings.Contains(output.String(), text) {
+				t.Errorf("Height %d: expected %q to be hidden", tt.height, text)
+			}
+		}
+	}
+
+	// Beside the board, only the controls have to go for 20 rows.
+	var output strings.Builder
+	renderer := NewPlainRenderer(&output, 100)
+	renderer.size = func() (int, int) { return 100, 20 }
+	if err := drawScreen(renderer, g); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(output.String(), "\n")
+	if len(lines) > 21 || !strings.Contains(lines[4], "┌") || !strings.Contains(lines[4], "Queens: 0/6") || strings.Contains(output.String(), "Controls:") || !strings.Contains(output.String(), "Prizes:") {
+		t.Errorf("Unexpected wide layout:\n%s", output.String())
+	}
+
+	// A boxed panel is never wrapped: too wide to go beside the board, the
+	// panels are stacked instead.
+	box := []string{"┌" + strings.Repeat("─", 38) + "┐", "│" + strings.Repeat(" ", 38) + "│"}
+	panels := []panel{
+		{lines: []string{strings.Repeat("#", 20)}, place: placeBoard},
+		{lines: []string{strings.Repeat("word ", 12)}, place: placeSide},
+		{lines: box, place: placeSide, boxed: true},
+	}
+	if layout := layoutPanels(panels, 60, 0); len(layout.lines) != 6 || strings.TrimSpace(layout.lines[0]) != strings.Repeat("#", 20) {
+		t.Errorf("Expected the panels stacked:\n%s", strings.Join(layout.lines, "\n"))
+	}
+	layout := layoutPanels(panels, 70, 0)
+	for _, line := range box {
+		if !slices.ContainsFunc(layout.lines, func(l string) bool { return strings.HasSuffix(l, line) }) {
+			t.Errorf("Expected the box intact beside the board:\n%s", strings.Join(layout.lines, "\n"))
+		}
+	}
+
+	wrapped := wrapLine("\033[33mone two three\033[0m", 7)
+	if !slices.Equal(wrapped, []string{"\033[33mone two\033[0m", "\033[33mthree\033[0m"}) {
+		t.Errorf("Unexpected wrapped lines %q", wrapped)
+	}
+
+	if got := clipLine("\033[32mabcdef\033[0m", 3); got != "\033[32mabc\033[0m" {
+		t.Errorf("Unexpected clipped line %q", got)
+	}
+	if got := clipLine("abc", 3); got != "abc" {
+		t.Errorf("A line that fits should be left alone, got %q", got)
+	}
+}
+
+func TestScreen(t *testing.T) {
+	var output strings.Builder
+	screen := NewScreen(&output)
+	draw := func(lines ...string) string {
+		output.Reset()
+		if err := screen.Draw(lines); err != nil {
+			t.Fatalf("Draw failed: %v", err)
+		}
+		return output.String()
+	}
+
+	if got := draw("one", "two", "three"); !strings.Contains(got, "\033[2J") || !strings.Contains(got, "\033[3;1Hthree") {
+		t.Errorf("The first frame should clear and draw everything, got %q", got)
+	}
+
+	expected := beginSynchronizedUpdate + "\033[2;1HTWO\033[K" + "\033[3;1H\033[J" + endSynchronizedUpdate
+	if got := draw("one", "TWO"); got != expected {
+		t.Errorf("Expected only the changed line, got %q", got)
+	}
+	if got := draw("one", "TWO"); got != "" {
+		t.Errorf("Expected nothing for the same frame, got %q", got)
+	}
+
+	screen.Invalidate()
+	if got := draw("one", "TWO"); !strings.Contains(got, "\033[2J") || !strings.Contains(got, "\033[1;1Hone") {
+		t.Errorf("After Invalidate the frame should be redrawn, got %q", got)
+	}
+}
+
+func TestBoardLabels(t *testing.T) {
+	files, ranks := boardLabels(LabelsChess, 20)
+	if files[0] != "a" || files[19] != "t" || ranks[0] != "20" || ranks[19] != "1" {
+		t.Errorf("Unexpected chess labels %v %v", files, ranks)
+	}
+	if files, ranks := boardLabels(LabelsNone, 8); files != nil || ranks != nil {
+		t.Errorf("Expected no labels, got %v %v", files, ranks)
+	}
+
+	q := NewQueens(12)
+	board := q.View(3, 2, false, false, nil)
+	board.Files, board.Ranks = boardLabels(LabelsNumeric, 12)
+	lines := boardLines(board, false)
+	if len(lines) != 2*12+2 {
+		t.Fatalf("Expected %d lines, got %d", 2*12+2, len(lines))
+	}
+	for i, line := range lines {
+		if getVisibleLength(line) != getVisibleLength(lines[0]) {
+			t.Errorf("Line %d is %d wide, not %d: %q", i, getVisibleLength(line), getVisibleLength(lines[0]), line)
+		}
+	}
+	if !strings.HasPrefix(lines[1], " 0 │") || !strings.HasPrefix(lines[23], "11 │") || !strings.HasSuffix(lines[25], "10  11  ") {
+		t.Errorf("Unexpected labels:\n%s", strings.Join(lines, "\n"))
+	}
+	if got := formatCursor(LabelsNumeric, &q, 3, 2); got != "r3c2" {
+		t.Errorf("Expected r3c2, got %q", got)
+	}
+	if got := formatCursor(LabelsChess, &q, 3, 2); got != "c9" {
+		t.Errorf("Expected c9, got %q", got)
+	}
+
+	g := newTestGame(t, DefaultBoardSize)
+	if g.execute(":labels numeric") || g.labels != LabelsNumeric {
+		t.Errorf("Expected numeric labels, got %v: %s", g.labels, g.commandError)
+	}
+	if g.execute(":labels roman"); g.commandError == "" || g.labels != LabelsNumeric {
+		t.Errorf("An unknown style should be an error, got %v", g.labels)
+	}
+
+	// Commands take cells as the numeric labels name them.
+	if g.execute(":place r3c2"); !g.queens.HasQueen(3, 2) {
+		t.Errorf("Expected a queen on r3c2, got\n%s", g.queens.Grid())
+	}
+	if g.execute(":goto R0C7"); g.cursorRow != 0 || g.cursorCol != 7 {
+		t.Errorf("Expected the cursor on r0c7, got %d,%d", g.cursorRow, g.cursorCol)
+	}
+	for _, name := range []string{"r8c0", "r-1c0", "rc", "r3c", "r3x2"} {
+		if _, err := parseCell(name, 8); !errors.Is(err, ErrBadSquare) {
+			t.Errorf("%q: expected ErrBadSquare, got %v", name, err)
+		}
+	}
+	if pos, err := parseCell("r3", 20); err != nil || pos != (Position{Row: 17, Col: 17}) {
+		t.Errorf("Expected the chess square r3, got %v, %v", pos, err)
+	}
+
+	// The labels are kept with the game, like the symbol, but labels chosen
+	// in the session win over the saved ones.
+	g.execute(":w labelled")
+	g.execute(":labels none")
+	if g.execute(":e labelled"); g.labels != LabelsNone {
+		t.Errorf("Expected the labels chosen to stay, got %v", g.labels)
+	}
+	if g.execute(":size 6"); g.labels != LabelsNone {
+		t.Errorf("Expected the labels chosen to stay on another size, got %v", g.labels)
+	}
+
+	fresh := NewGame(g.terminal, &recordingRenderer{}, "", g.config, "alice", 6, g.fundamentals, nil, false, false, nil)
+	if fresh.execute(":e labelled"); fresh.labels != LabelsNumeric {
+		t.Errorf("Expected the saved numeric labels back, got %v", fresh.labels)
+	}
+	chess := LabelsChess
+	fixed := NewGame(g.terminal, &recordingRenderer{}, "", g.config, "alice", 6, g.fundamentals, nil, false, false, &chess)
+	if fixed.execute(":e labelled"); fixed.labels != LabelsChess {
+		t.Errorf("Expected -labels to win over the saved labels, got %v", fixed.labels)
+	}
+}
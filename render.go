@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Renderer draws the game screen from plain data, so that the game doesn't
+// depend on how or where it is shown. A screen is drawn by calling Begin,
+// then the panels in the order they are listed, then End.
+type Renderer interface {
+	Begin()
+	Title(size int, solved bool)
+	Board(board BoardView)
+	Status(status StatusView)
+	DiscoveryGrid(grid DiscoveryView)
+	VariantsGrid(grid VariantsView)
+	Prizes(prizes PrizesView)
+	Controls(controls []ControlView)
+	CommandLine(line CommandLineView)
+	End() error
+}
+
+// LabelStyle is how the files and ranks around the board are labelled.
+type LabelStyle int
+
+const (
+	LabelsNone LabelStyle = iota
+	// LabelsChess labels the files a, b, ... left to right and the ranks
+	// 1, 2, ... bottom to top, as squares are named in commands.
+	LabelsChess
+	// LabelsNumeric numbers the columns left to right and the rows top to
+	// bottom from 0, as positions are stored.
+	LabelsNumeric
+)
+
+var labelStyleNames = map[LabelStyle]string{
+	LabelsNone:    "none",
+	LabelsChess:   "chess",
+	LabelsNumeric: "numeric",
+}
+
+func (s LabelStyle) MarshalText() ([]byte, error) {
+	name, ok := labelStyleNames[s]
+	if !ok {
+		return nil, fmt.Errorf("unknown label style %d", int(s))
+	}
+	return []byte(name), nil
+}
+
+func (s *LabelStyle) UnmarshalText(text []byte) error {
+	for style, name := range labelStyleNames {
+		if name == string(text) {
+			*s = style
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown label style %q", text)
+}
+
+// boardLabels returns the labels of the files, left to right, and of the
+// ranks, top to bottom, or nil for no labels.
+func boardLabels(style LabelStyle, size int) (files []string, ranks []string) {
+	if style == LabelsNone {
+		return nil, nil
+	}
+
+	for i := range size {
+		if style == LabelsNumeric {
+			files = append(files, strconv.Itoa(i))
+			ranks = append(ranks, strconv.Itoa(i))
+		} else {
+			files = append(files, fileName(i))
+			ranks = append(ranks, strconv.Itoa(size-i))
+		}
+	}
+	return files, ranks
+}
+
+// formatCursor names the cursor's cell the way the labels do: as a square
+// like "c5", or by row and column from 0, like "r3c2".
+func formatCursor(style LabelStyle, queens *Queens, row, col int) string {
+	if style == LabelsNumeric {
+		return fmt.Sprintf("r%dc%d", row, col)
+	}
+	return queens.Square(row, col)
+}
+
+// parseCell reads a cell named either way formatCursor names it, so that
+// commands take what the status line shows.
+func parseCell(name string, size int) (Position, error) {
+	lower := strings.ToLower(strings.TrimSpace(name))
+	rowText, colText, ok := strings.Cut(strings.TrimPrefix(lower, "r"), "c")
+	if !ok || !strings.HasPrefix(lower, "r") {
+		return ParseSquare(name, size)
+	}
+
+	row, rowErr := strconv.Atoi(rowText)
+	col, colErr := strconv.Atoi(colText)
+	if rowErr != nil || colErr != nil || row < 0 || row >= size || col < 0 || col >= size {
+		return Position{}, fmt.Errorf("%w %q", ErrBadSquare, name)
+	}
+	return Position{Row: row, Col: col}, nil
+}
+
+// boardLocator is a Renderer that knows where on the screen it drew the
+// board, as the 1-based column and row of its top-left corner, so that
+// mouse events can be mapped to cells.
+type boardLocator interface {
+	BoardOrigin() (x, y int)
+}
+
+// resizable is a Renderer drawing on a terminal window, which has to be
+// told when the window is resized to redraw all of it.
+type resizable interface {
+	Resized()
+}
+
+type StatusView struct {
+	Count  int
+	Size   int
+	Cursor string
+	Solved bool
+	Symbol string
+	Hard   bool
+	// Help is whether attacked cells are shown. Hard mode has no help.
+	Help bool
+	// Reachable is the number of solutions the board can still be
+	// completed to, up to maxReachableCount, -1 if not counted, or
+	// reachableUnknown if counting took too long.
+	Reachable int
+	// Untracked is whether the board is too large for its fundamental
+	// solutions to be generated, so finding them isn't tracked.
+	Untracked bool
+	Message   string
+}
+
+// DiscoveryView is which fundamental solutions have been found: Solved
+// holds 1 for each one found, and Orbits the number of solutions each
+// stands for. Summary is whether there are too many to show one by one.
+type DiscoveryView struct {
+	Solved  []int
+	Orbits  []int
+	Summary bool
+}
+
+// VariantsView is how many distinct solutions have been found out of
+// Total, which is 0 if unknown, and for each fundamental solution, which of
+// its variants. Variants is nil when there are too many to show.
+type VariantsView struct {
+	Found    int
+	Total    int
+	Variants [][]bool
+}
+
+type PrizesView struct {
+	Prizes []Prize
+	Won    []bool
+}
+
+// ControlView is one line of the controls: the keys, as in "u/Ctrl-R", and
+// what they do.
+type ControlView struct {
+	Keys  string
+	Label string
+}
+
+// CommandLineView is the command being typed, with the cursor position in
+// runes, or the error from the last one run.
+type CommandLineView struct {
+	Text   string
+	Cursor int
+	Error  string
+}
+
+// drawScreen draws the whole game with a renderer.
+func drawScreen(r Renderer, g *Game) error {
+	queens := &g.queens
+	isSolved := queens.IsSolved()
+	solved := g.solved()
+	found := g.found()
+
+	r.Begin()
+	r.Title(queens.Size(), isSolved)
+	board := queens.View(g.cursorRow, g.cursorCol, g.showHelp, g.hard, g.hint)
+	board.Files, board.Ranks = boardLabels(g.labels, queens.Size())
+	r.Board(board)
+	r.Status(StatusView{
+		Count:     queens.Count(),
+		Size:      queens.Size(),
+		Cursor:    formatCursor(g.labels, queens, g.cursorRow, g.cursorCol),
+		Solved:    isSolved,
+		Symbol:    queens.GetSymbol(),
+		Hard:      g.hard,
+		Help:      g.showHelp,
+		Reachable: g.reachable,
+		Untracked: queens.Size() > maxGeneratedSize,
+		Message:   g.message,
+	})
+
+	variants := variantsView(g.fundamentals, g.orbits, queens.Size(), found)
+	if g.showVariants {
+		r.VariantsGrid(variants)
+	} else {
+		r.DiscoveryGrid(DiscoveryView{
+			Solved:  solved,
+			Orbits:  g.orbits,
+			Summary: len(solved) > 0 && variants.Variants == nil,
+		})
+	}
+
+	r.Prizes(prizesView(g.prizes, countSolved(solved), len(found)))
+	r.Controls(controlViews(g.terminal.keymap, g.noExit, g.hard))
+
+	if g.commandMode || g.commandError != "" {
+		r.CommandLine(CommandLineView{
+			Text:   g.commandLine.String(),
+			Cursor: g.commandLine.Cursor(),
+			Error:  g.commandError,
+		})
+	}
+	return r.End()
+}
+
+const discoveryGridMaxCells = 24
+
+func variantsView(fundamentals [][]Position, orbits []int, size int, found map[string]bool) VariantsView {
+	view := VariantsView{Found: len(found)}
+	for _, orbit := range orbits {
+		view.Total += orbit
+	}
+
+	// Larger boards have far too many fundamentals to show one cell each.
+	if len(fundamentals) > discoveryGridMaxCells {
+		return view
+	}
+
+	for _, fundamental := range fundamentals {
+		symmetries := NewSymmetries(fundamental, size)
+		var variants []bool
+		for _, variant := range symmetries.Variants() {
+			variants = append(variants, found[SolutionKey(variant)])
+		}
+		view.Variants = append(view.Variants, variants)
+	}
+	return view
+}
+
+func prizesView(prizes []Prize, solvedCount int, foundCount int) PrizesView {
+	view := PrizesView{Prizes: prizes}
+	for _, prize := range prizes {
+		view.Won = append(view.Won, prize.Won(solvedCount, foundCount))
+	}
+	return view
+}
+
+func formatPrizeText(prize Prize) string {
+	return fmt.Sprintf("[+ %3d¢] %s", prize.Cents, prize.Label)
+}
+
+func formatReachable(reachable int) string {
+	switch {
+	case reachable == reachableUnknown:
+		return "Solutions still reachable: unknown, too many to search"
+	case reachable == 0:
+		return "Dead end: no solution is reachable"
+	case reachable >= maxReachableCount:
+		return fmt.Sprintf("%d+ solutions still reachable", maxReachableCount)
+	case reachable == 1:
+		return "1 solution still reachable"
+	default:
+		return fmt.Sprintf("%d solutions still reachable", reachable)
+	}
+}
+
+type controlsRow struct {
+	codes []Code
+	label string
+}
+
+var controlsRows = []controlsRow{
+	{[]Code{CodeExit}, "Exit"},
+	{[]Code{CodeReset}, "Reset board"},
+	{[]Code{CodeUndo, CodeRedo}, "Undo / redo"},
+	{[]Code{CodeHelp}, "Toggle help"},
+	{[]Code{CodeHint}, "Show a hint"},
+	{[]Code{CodePlace}, "Toggle queen"},
+	{[]Code{CodeSymbolBlack, CodeSymbolWhite, CodeSymbolAscii}, "Change symbol"},
+	{[]Code{CodeVariants}, "Variants view"},
+	{[]Code{CodeUp, CodeLeft, CodeDown, CodeRight}, "Move cursor"},
+	{[]Code{CodeCommand}, "Commands"},
+}
+
+// controlViews lists the controls that apply in the game, leaving out
+// the ones with no keys bound.
+func controlViews(keymap Keymap, noExit bool, hard bool) []ControlView {
+	var controls []ControlView
+	for _, row := range controlsRows {
+		if (row.codes[0] == CodeExit && noExit) || (hard && (row.codes[0] == CodeHelp || row.codes[0] == CodeHint)) {
+			continue
+		}
+		keys := controlsKeys(keymap, row.codes)
+		if keys == "" {
+			continue
+		}
+		controls = append(controls, ControlView{Keys: keys, Label: row.label})
+	}
+	return controls
+}
+
+// controlsKeys names the first key bound to each code, as in "u/Ctrl-R",
+// or "" if one of them has no key.
+func controlsKeys(keymap Keymap, codes []Code) string {
+	var names []string
+	for _, code := range codes {
+		keys := keymap.Keys(code)
+		if len(keys) == 0 {
+			return ""
+		}
+		names = append(names, displayKey(keys[0]))
+	}
+
+	if slices.Equal(names, []string{"Up", "Left", "Down", "Right"}) {
+		return "Arrows"
+	}
+	return strings.Join(names, "/")
+}
+
+// displayKey turns a key name from the keymap into the way the controls
+// show it: "space" as "Space" and "ctrl-r" as "Ctrl-R".
+func displayKey(name string) string {
+	parts := strings.Split(name, "-")
+	if len(parts) > 1 && parts[len(parts)-1] == "" {
+		// The key itself is '-'.
+		parts = append(parts[:len(parts)-2], "-")
+	}
+	ctrl := len(parts) > 1 && parts[0] == "ctrl"
+	for i, part := range parts {
+		if utf8.RuneCountInString(part) > 1 || (ctrl && i == len(parts)-1) {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "-")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrBadSaveName   = errors.New("save names may only use letters, digits, '-' and '_'")
+	ErrBadPlayerName = errors.New("player names may not contain path separators or start with '.'")
+	ErrNoSave        = errors.New("no such save")
+	ErrBadSave       = errors.New("save does not fit its board")
+)
+
+// SavedGame is a game in progress as written under ~/.queens/saves: the
+// board with its undo history, and the view settings around it.
+type SavedGame struct {
+	Board     Queens      `json:"board"`
+	History   History     `json:"history"`
+	CursorRow int         `json:"cursor_row"`
+	CursorCol int         `json:"cursor_col"`
+	Symbol    QueenSymbol `json:"symbol"`
+	Hard      bool        `json:"hard"`
+	ShowHelp  bool        `json:"help,omitempty"`
+	Labels    LabelStyle  `json:"labels,omitempty"`
+	// Solver is the board :solve or :load filled in, while it doesn't count.
+	Solver []Position `json:"solver,omitempty"`
+}
+
+// Queens returns the saved board with its symbol and history restored.
+func (s SavedGame) Queens() Queens {
+	queens := s.Board.Clone()
+	queens.SetSymbol(s.Symbol)
+	queens.SetHistory(s.History)
+	return queens
+}
+
+// check makes sure there is a board and that its history leads to it:
+// replaying the moves done from an empty board must give the saved board,
+// and the moves undone must then redo, or undoing and redoing them would
+// corrupt it.
+func (s SavedGame) check() error {
+	size := s.Board.Size()
+	if size == 0 {
+		return ErrBadSave
+	}
+	inBounds := func(positions ...Position) bool {
+		for _, pos := range positions {
+			if pos.Row < 0 || pos.Row >= size || pos.Col < 0 || pos.Col >= size {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, moves := range [][]Move{s.History.Done, s.History.Undone} {
+		for _, move := range moves {
+			if !inBounds(Position{Row: move.Row, Col: move.Col}) || !inBounds(move.Queens...) || !inBounds(move.Previous...) {
+				return ErrBadSave
+			}
+		}
+	}
+
+	replayed := NewQueens(size)
+	for _, move := range s.History.Done {
+		if !replayed.apply(move) {
+			return ErrBadSave
+		}
+	}
+	if !samePositions(replayed.queens, s.Board.queens) {
+		return ErrBadSave
+	}
+	for i := len(s.History.Undone) - 1; i >= 0; i-- {
+		if !replayed.apply(s.History.Undone[i]) {
+			return ErrBadSave
+		}
+	}
+	return nil
+}
+
+// GetSavesDir returns the directory holding a player's saved games.
+func GetSavesDir(playerName string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".queens", "saves", playerName)
+	}
+	return filepath.Join(home, ".queens", "saves", playerName)
+}
+
+func checkSaveName(name string) error {
+	if !isPlainFileName(name) {
+		return ErrBadSaveName
+	}
+	for _, char := range name {
+		switch {
+		case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', char >= '0' && char <= '9', char == '-', char == '_':
+		default:
+			return ErrBadSaveName
+		}
+	}
+	return nil
+}
+
+// autosaveName names the save kept for each board size between sessions.
+// Its leading dot keeps it apart from the names players can choose.
+func autosaveName(size int) string {
+	return ".autosave-" + strconv.Itoa(size)
+}
+
+// checkPlayerName makes sure a player's saves stay in a directory of their
+// own under ~/.queens/saves.
+func checkPlayerName(playerName string) error {
+	if !isPlainFileName(playerName) {
+		return ErrBadPlayerName
+	}
+	return nil
+}
+
+// isPlainFileName reports whether name is a single file name that stays
+// in its directory. Names with a leading dot are left for autosaves.
+func isPlainFileName(name string) bool {
+	return name != "" && filepath.IsLocal(name) && !strings.ContainsAny(name, `/\`) && !strings.HasPrefix(name, ".")
+}
+
+func savePath(playerName, name string) (string, error) {
+	if err := checkPlayerName(playerName); err != nil {
+		return "", err
+	}
+	return filepath.Join(GetSavesDir(playerName), name+".json"), nil
+}
+
+// ListSaves returns the names of the player's saved games, in name order.
+func ListSaves(playerName string) []string {
+	if checkPlayerName(playerName) != nil {
+		return nil
+	}
+	entries, _ := os.ReadDir(GetSavesDir(playerName))
+
+	var names []string
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if ok && checkSaveName(name) == nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func WriteSave(playerName, name string, save SavedGame) error {
+	if err := checkSaveName(name); err != nil {
+		return err
+	}
+	path, err := savePath(playerName, name)
+	if err != nil {
+		return err
+	}
+	return writeSave(path, save)
+}
+
+func ReadSave(playerName, name string) (SavedGame, error) {
+	if err := checkSaveName(name); err != nil {
+		return SavedGame{}, err
+	}
+	path, err := savePath(playerName, name)
+	if err != nil {
+		return SavedGame{}, err
+	}
+	return readSave(path)
+}
+
+func writeSave(path string, save SavedGame) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(save, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+func readSave(path string) (SavedGame, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return SavedGame{}, ErrNoSave
+		}
+		return SavedGame{}, err
+	}
+
+	var save SavedGame
+	if err := json.Unmarshal(data, &save); err != nil {
+		return SavedGame{}, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	if err := save.check(); err != nil {
+		return SavedGame{}, err
+	}
+	return save, nil
+}
+
+// WriteAutosave keeps the game for the next session on the same board size.
+func WriteAutosave(playerName string, save SavedGame) error {
+	path, err := savePath(playerName, autosaveName(save.Board.Size()))
+	if err != nil {
+		return err
+	}
+	return writeSave(path, save)
+}
+
+// ReadAutosave returns the game left on the given board size, or ErrNoSave.
+func ReadAutosave(playerName string, size int) (SavedGame, error) {
+	path, err := savePath(playerName, autosaveName(size))
+	if err != nil {
+		return SavedGame{}, err
+	}
+	return readSave(path)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Synchronized output (mode 2026) has the terminal hold a frame back until
+// it is complete, so that it is never shown half drawn. Terminals without it
+// ignore the mode like any other they don't know.
+const (
+	beginSynchronizedUpdate = "\033[?2026h"
+	endSynchronizedUpdate   = "\033[?2026l"
+)
+
+// Screen is a terminal that remembers the lines last drawn on it, so that
+// each frame only rewrites the lines that changed. The first frame, and the
+// first after Invalidate, clears the terminal and draws everything.
+type Screen struct {
+	w     io.Writer
+	lines []string
+	valid bool
+}
+
+func NewScreen(w io.Writer) *Screen {
+	return &Screen{w: w}
+}
+
+// Invalidate forgets what is on the terminal, for when it may have been
+// changed behind the screen's back, as when the window is resized.
+func (s *Screen) Invalidate() {
+	s.lines = nil
+	s.valid = false
+}
+
+// Draw shows a frame of lines, the first on the top row of the terminal.
+func (s *Screen) Draw(lines []string) error {
+	var out strings.Builder
+	if !s.valid {
+		out.WriteString("\033[H\033[2J")
+	}
+
+	for i, line := range lines {
+		if i < len(s.lines) && s.lines[i] == line {
+			continue
+		}
+		// Move to the line, draw it and clear what was left of the old one.
+		fmt.Fprintf(&out, "\033[%d;1H%s\033[K", i+1, line)
+	}
+	if len(lines) < len(s.lines) {
+		fmt.Fprintf(&out, "\033[%d;1H\033[J", len(lines)+1)
+	}
+
+	s.lines = lines
+	s.valid = true
+	if out.Len() == 0 {
+		return nil
+	}
+
+	_, err := io.WriteString(s.w, beginSynchronizedUpdate+out.String()+endSynchronizedUpdate)
+	return err
+}
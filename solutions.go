@@ -1,9 +1,15 @@
 package main
 
 import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -20,89 +26,148 @@ const (
 	TransformMirrorAD
 )
 
+var transformNames = map[Transform]string{
+	TransformIdentity: "identity",
+	TransformRot90:    "rot90",
+	TransformRot180:   "rot180",
+	TransformRot270:   "rot270",
+	TransformMirrorH:  "mirror-h",
+	TransformMirrorV:  "mirror-v",
+	TransformMirrorD:  "mirror-d",
+	TransformMirrorAD: "mirror-ad",
+}
+
+// String describes the transform as it is shown to the player.
+func (t Transform) String() string {
+	switch t {
+	case TransformIdentity:
+		return "as drawn"
+	case TransformRot90:
+		return "rotated 90°"
+	case TransformRot180:
+		return "rotated 180°"
+	case TransformRot270:
+		return "rotated 270°"
+	case TransformMirrorH:
+		return "mirrored left to right"
+	case TransformMirrorV:
+		return "mirrored top to bottom"
+	case TransformMirrorD:
+		return "mirrored on the diagonal"
+	case TransformMirrorAD:
+		return "mirrored on the anti-diagonal"
+	default:
+		return "unknown"
+	}
+}
+
+func (t Transform) MarshalText() ([]byte, error) {
+	name, ok := transformNames[t]
+	if !ok {
+		return nil, fmt.Errorf("unknown transform %d", int(t))
+	}
+	return []byte(name), nil
+}
+
+func (t *Transform) UnmarshalText(text []byte) error {
+	for transform, name := range transformNames {
+		if name == string(text) {
+			*t = transform
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown transform %q", text)
+}
+
 type Symmetries struct {
 	transforms map[Transform][]Position
 }
 
-func NewSymmetries(positions []Position) Symmetries {
+func NewSymmetries(positions []Position, size int) Symmetries {
 	s := Symmetries{
 		transforms: make(map[Transform][]Position),
 	}
 
-	s.transforms[TransformIdentity] = normalizePositions(positions)
-	s.transforms[TransformRot90] = normalizePositions(rotate90(positions))
-	s.transforms[TransformRot180] = normalizePositions(rotate180(positions))
-	s.transforms[TransformRot270] = normalizePositions(rotate270(positions))
-	s.transforms[TransformMirrorH] = normalizePositions(mirrorHorizontal(positions))
-	s.transforms[TransformMirrorV] = normalizePositions(mirrorVertical(positions))
-	s.transforms[TransformMirrorD] = normalizePositions(mirrorDiagonal(positions))
-	s.transforms[TransformMirrorAD] = normalizePositions(mirrorAntiDiagonal(positions))
+	for transform := TransformIdentity; transform <= TransformMirrorAD; transform++ {
+		s.transforms[transform] = normalizePositions(transform.applyAll(positions, size))
+	}
 
 	return s
 }
 
-func (s *Symmetries) Matches(board []Position) bool {
+// Matches returns the transforms that turn the solution into board, in
+// Transform order. It is empty when board is not a variant of the solution,
+// and holds more than one transform when the solution is itself symmetric.
+func (s *Symmetries) Matches(board []Position) []Transform {
 	normalized := normalizePositions(board)
-	for _, transform := range s.transforms {
-		if positionsEqual(normalized, transform) {
-			return true
+	var matches []Transform
+	for transform := TransformIdentity; transform <= TransformMirrorAD; transform++ {
+		if positionsEqual(normalized, s.transforms[transform]) {
+			matches = append(matches, transform)
 		}
 	}
-	return false
+	return matches
 }
 
-func rotate90(positions []Position) []Position {
-	result := make([]Position, len(positions))
-	for i, pos := range positions {
-		result[i] = Position{Row: pos.Col, Col: boardSize - 1 - pos.Row}
-	}
-	return result
-}
-
-func rotate180(positions []Position) []Position {
-	result := make([]Position, len(positions))
-	for i, pos := range positions {
-		result[i] = Position{Row: boardSize - 1 - pos.Row, Col: boardSize - 1 - pos.Col}
-	}
-	return result
-}
-
-func rotate270(positions []Position) []Position {
-	result := make([]Position, len(positions))
-	for i, pos := range positions {
-		result[i] = Position{Row: boardSize - 1 - pos.Col, Col: pos.Row}
-	}
-	return result
+// Stabilizer returns the transforms that leave the solution unchanged, in
+// Transform order. It always holds the identity; a solution that looks the
+// same rotated by 180° also holds TransformRot180, and so on.
+func (s *Symmetries) Stabilizer() []Transform {
+	return s.Matches(s.transforms[TransformIdentity])
 }
 
-func mirrorHorizontal(positions []Position) []Position {
-	result := make([]Position, len(positions))
-	for i, pos := range positions {
-		result[i] = Position{Row: pos.Row, Col: boardSize - 1 - pos.Col}
-	}
-	return result
+// OrbitSize returns the number of distinct solutions the transforms make
+// from this one: 8 divided by the size of its stabilizer.
+func (s *Symmetries) OrbitSize() int {
+	return 8 / len(s.Stabilizer())
 }
 
-func mirrorVertical(positions []Position) []Position {
-	result := make([]Position, len(positions))
-	for i, pos := range positions {
-		result[i] = Position{Row: boardSize - 1 - pos.Row, Col: pos.Col}
+// Variants returns the distinct boards among the transforms, in Transform
+// order. A solution with symmetries of its own has fewer than eight.
+func (s *Symmetries) Variants() [][]Position {
+	var variants [][]Position
+	for transform := TransformIdentity; transform <= TransformMirrorAD; transform++ {
+		candidate := s.transforms[transform]
+		duplicate := false
+		for _, variant := range variants {
+			if positionsEqual(variant, candidate) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			variants = append(variants, candidate)
+		}
 	}
-	return result
+	return variants
 }
 
-func mirrorDiagonal(positions []Position) []Position {
-	result := make([]Position, len(positions))
-	for i, pos := range positions {
-		result[i] = Position{Row: pos.Col, Col: pos.Row}
+// apply moves one cell of a size x size board by the transform.
+func (t Transform) apply(pos Position, size int) Position {
+	switch t {
+	case TransformRot90:
+		return Position{Row: pos.Col, Col: size - 1 - pos.Row}
+	case TransformRot180:
+		return Position{Row: size - 1 - pos.Row, Col: size - 1 - pos.Col}
+	case TransformRot270:
+		return Position{Row: size - 1 - pos.Col, Col: pos.Row}
+	case TransformMirrorH:
+		return Position{Row: pos.Row, Col: size - 1 - pos.Col}
+	case TransformMirrorV:
+		return Position{Row: size - 1 - pos.Row, Col: pos.Col}
+	case TransformMirrorD:
+		return Position{Row: pos.Col, Col: pos.Row}
+	case TransformMirrorAD:
+		return Position{Row: size - 1 - pos.Col, Col: size - 1 - pos.Row}
+	default:
+		return pos
 	}
-	return result
 }
 
-func mirrorAntiDiagonal(positions []Position) []Position {
+func (t Transform) applyAll(positions []Position, size int) []Position {
 	result := make([]Position, len(positions))
 	for i, pos := range positions {
-		result[i] = Position{Row: boardSize - 1 - pos.Col, Col: boardSize - 1 - pos.Row}
+		result[i] = t.apply(pos, size)
 	}
 	return result
 }
@@ -119,6 +184,16 @@ func normalizePositions(positions []Position) []Position {
 	return result
 }
 
+// SolutionKey encodes a board as the column of the queen in each row, one
+// base-36 digit per row, so "36271405" is the first 8x8 fundamental.
+func SolutionKey(positions []Position) string {
+	var key strings.Builder
+	for _, pos := range normalizePositions(positions) {
+		key.WriteString(strconv.FormatInt(int64(pos.Col), 36))
+	}
+	return key.String()
+}
+
 func positionsEqual(a, b []Position) bool {
 	if len(a) != len(b) {
 		return false
@@ -131,42 +206,224 @@ func positionsEqual(a, b []Position) bool {
 	return true
 }
 
-func LoadFundamentalSolutions() ([][]Position, error) {
-	files, err := filepath.Glob("boards/*.txt")
-	if err != nil {
-		return nil, err
-	}
+// maxGeneratedSize is the largest board whose fundamental solutions are
+// enumerated at startup; above it the enumeration takes too long.
+const maxGeneratedSize = 14
 
-	sort.Strings(files)
+//go:embed boards/*.txt
+var embeddedBoards embed.FS
 
-	var solutions [][]Position
-	for _, file := range files {
-		content, err := os.ReadFile(file)
+// BoardsFS returns the directory the board files are read from: dir when it
+// is set, otherwise the boards built into the binary.
+func BoardsFS(dir string) (fs.FS, error) {
+	if dir != "" {
+		info, err := os.Stat(dir)
 		if err != nil {
 			return nil, err
 		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", dir)
+		}
+		return os.DirFS(dir), nil
+	}
+	return fs.Sub(embeddedBoards, "boards")
+}
 
-		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
-		var positions []Position
-		for row, line := range lines {
-			line = strings.TrimSpace(line)
-			col := strings.Index(line, "Q")
-			if col != -1 {
-				positions = append(positions, Position{Row: row, Col: col})
-			}
+// LoadFundamentalSolutions reads the boards of the given size from fsys,
+// in file name order. Every board file must be valid, whatever its size;
+// the first problem found is returned as a *BoardError.
+func LoadFundamentalSolutions(fsys fs.FS, size int) ([][]Position, error) {
+	boards, errs := readBoardFiles(fsys)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	var solutions [][]Position
+	for _, board := range boards {
+		if len(board.positions) == size {
+			solutions = append(solutions, board.positions)
 		}
-		solutions = append(solutions, positions)
 	}
 
 	return solutions, nil
 }
 
-func FindMatchingSolution(userBoard []Position, fundamentals [][]Position) int {
+// classicFundamentals keeps the traditional numbering of the 8x8
+// fundamentals, s01 to s12, written as the column of the queen in each row.
+var classicFundamentals = map[int][]string{
+	8: {
+		"36271405", "41362750", "31625740", "35720641", "25703641", "42736051",
+		"46302751", "30475261", "25307461", "51603742", "36074152", "53607142",
+	},
+}
+
+// GenerateFundamentalSolutions enumerates every solution on the board and
+// keeps one per symmetry class: the one whose columns, read from the top
+// row down, are lexicographically smallest. Since the solver yields solutions
+// in that same order, the fundamentals come out numbered by it, except on
+// sizes listed in classicFundamentals, which are numbered and drawn as listed
+// there. The numbering never depends on boards: a board only replaces the
+// orientation of the fundamental it is a variant of.
+func GenerateFundamentalSolutions(size int, boards [][]Position) [][]Position {
+	if size > maxGeneratedSize {
+		return nil
+	}
+
+	var fundamentals [][]Position
+	key := make([]byte, size)
+	NewSolver(size).Each(func(solution []Position) bool {
+		solutionKeyInto(key, solution)
+		if CanonicalKey(solution, size) == string(key) {
+			fundamentals = append(fundamentals, append([]Position(nil), solution...))
+		}
+		return true
+	})
+
+	if classic, ok := classicFundamentals[size]; ok {
+		fundamentals = classicOrder(fundamentals, classic, size)
+	}
+	return orientByBoards(fundamentals, boards, size)
+}
+
+// LoadFundamentals generates the fundamental solutions for the given size,
+// drawn as in the board files in boardsDir, or the built-in boards if empty.
+func LoadFundamentals(boardsDir string, size int) ([][]Position, error) {
+	boardsFS, err := BoardsFS(boardsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	boards, err := LoadFundamentalSolutions(boardsFS, size)
+	if err != nil {
+		return nil, boardsDirError(boardsDir, err)
+	}
+
+	return GenerateFundamentalSolutions(size, boards), nil
+}
+
+// boardsDirError puts the boards directory in front of the file name of a
+// *BoardError, so that it reads like "boards/s04.txt:3: ...".
+func boardsDirError(boardsDir string, err error) error {
+	var boardErr *BoardError
+	if errors.As(err, &boardErr) {
+		if boardsDir == "" {
+			boardsDir = "boards"
+		}
+		boardErr.File = filepath.Join(boardsDir, boardErr.File)
+	}
+	return err
+}
+
+// OrbitSizes returns the orbit size of each fundamental solution.
+func OrbitSizes(fundamentals [][]Position, size int) []int {
+	orbits := make([]int, len(fundamentals))
 	for i, fundamental := range fundamentals {
-		symmetries := NewSymmetries(fundamental)
-		if symmetries.Matches(userBoard) {
-			return i + 1
+		symmetries := NewSymmetries(fundamental, size)
+		orbits[i] = symmetries.OrbitSize()
+	}
+	return orbits
+}
+
+// FormatOrbitSum explains how the fundamentals add up to all solutions,
+// as in "11×8 + 1×4* = 92". Orbits smaller than 8 are starred.
+func FormatOrbitSum(orbits []int) string {
+	counts := make(map[int]int)
+	total := 0
+	for _, orbit := range orbits {
+		counts[orbit]++
+		total += orbit
+	}
+
+	var terms []string
+	for _, orbit := range []int{8, 4, 2, 1} {
+		if counts[orbit] == 0 {
+			continue
+		}
+		term := fmt.Sprintf("%d×%d", counts[orbit], orbit)
+		if orbit < 8 {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+	return fmt.Sprintf("%s = %d", strings.Join(terms, " + "), total)
+}
+
+// classicOrder puts the fundamentals in the order of the classic boards,
+// provided each classic board is a variant of exactly one of them.
+func classicOrder(fundamentals [][]Position, classic []string, size int) [][]Position {
+	if len(classic) != len(fundamentals) {
+		return fundamentals
+	}
+
+	index := NewSolutionIndex(fundamentals, size)
+	ordered := make([][]Position, 0, len(classic))
+	used := make([]bool, len(fundamentals))
+	for _, key := range classic {
+		board := make([]Position, size)
+		for row, digit := range key {
+			col, _ := strconv.ParseInt(string(digit), 36, 0)
+			board[row] = Position{Row: row, Col: int(col)}
 		}
+
+		matchNum := index.Find(board)
+		if matchNum == -1 || used[matchNum-1] {
+			return fundamentals
+		}
+		used[matchNum-1] = true
+		ordered = append(ordered, board)
+	}
+	return ordered
+}
+
+// orientByBoards replaces the fundamentals that boards are variants of with
+// the boards themselves, keeping their numbers. Where several boards draw the
+// same fundamental, the first one is used.
+func orientByBoards(fundamentals [][]Position, boards [][]Position, size int) [][]Position {
+	index := NewSolutionIndex(fundamentals, size)
+	oriented := slices.Clone(fundamentals)
+	used := make([]bool, len(fundamentals))
+	for _, board := range boards {
+		matchNum := index.Find(board)
+		if matchNum == -1 || used[matchNum-1] {
+			continue
+		}
+		used[matchNum-1] = true
+		oriented[matchNum-1] = normalizePositions(board)
+	}
+	return oriented
+}
+
+// ParseBoard reads a board drawn one row per line with Q marking a queen,
+// returning the queens found and the number of rows. It accepts partial
+// placements; board files of solutions are read with ParseSolution.
+func ParseBoard(content string) ([]Position, int) {
+	lines := strings.Split(strings.TrimSpace(content), "\n")
+
+	var positions []Position
+	for row, line := range lines {
+		line = strings.TrimSpace(line)
+		col := strings.Index(line, "Q")
+		if col != -1 {
+			positions = append(positions, Position{Row: row, Col: col})
+		}
+	}
+	return positions, len(lines)
+}
+
+// FormatBoard draws the queens in the same format that ParseBoard reads.
+func FormatBoard(positions []Position, size int) string {
+	grid := make([][]byte, size)
+	for row := range grid {
+		grid[row] = []byte(strings.Repeat(".", size))
+	}
+	for _, pos := range positions {
+		grid[pos.Row][pos.Col] = 'Q'
+	}
+
+	var result strings.Builder
+	for _, line := range grid {
+		result.Write(line)
+		result.WriteString("\n")
 	}
-	return -1
+	return result.String()
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"math/bits"
+)
+
+var (
+	ErrInvalidPartial = errors.New("partial placement has attacking queens")
+	ErrUnsolvable     = errors.New("placement cannot be completed to a solution")
+)
+
+// Solver enumerates N-queens solutions by backtracking one row at a time,
+// keeping the attacked columns as bitmasks.
+type Solver struct {
+	size  int
+	fixed []int
+	// budget is how many more partial placements the search may try, or
+	// -1 for no limit. A search that runs out stops and sets exhausted.
+	budget    int
+	exhausted bool
+}
+
+// NewSolver returns a solver for an empty board of the given size.
+func NewSolver(size int) *Solver {
+	fixed := make([]int, size)
+	for i := range fixed {
+		fixed[i] = -1
+	}
+	return &Solver{size: size, fixed: fixed, budget: -1}
+}
+
+// NewSolverFrom returns a solver that only yields completions of the queens
+// already placed on the board.
+func NewSolverFrom(queens *Queens) (*Solver, error) {
+	s := NewSolver(queens.Size())
+	for _, pos := range queens.queens {
+		if queens.IsQueenUnderAttack(pos.Row, pos.Col) {
+			return nil, ErrInvalidPartial
+		}
+		s.fixed[pos.Row] = pos.Col
+	}
+	return s, nil
+}
+
+// Each calls visit with every solution in lexicographic order of columns.
+// The slice passed to visit is reused; visit must copy it to keep it.
+// Returning false from visit stops the search.
+func (s *Solver) Each(visit func(solution []Position) bool) {
+	solution := make([]Position, s.size)
+	s.place(0, 0, 0, 0, solution, visit)
+}
+
+// place fills row onwards. cols holds the attacked columns; diag and anti
+// hold the columns attacked along each diagonal direction, shifted to line
+// up with the current row.
+func (s *Solver) place(row int, cols, diag, anti uint64, solution []Position, visit func([]Position) bool) bool {
+	if s.budget == 0 {
+		s.exhausted = true
+		return false
+	}
+	if s.budget > 0 {
+		s.budget--
+	}
+
+	if row == s.size {
+		return visit(solution)
+	}
+
+	free := (uint64(1)<<s.size - 1) &^ (cols | diag | anti)
+	if s.fixed[row] != -1 {
+		free &= uint64(1) << s.fixed[row]
+	}
+
+	for free != 0 {
+		bit := free & -free
+		free ^= bit
+
+		solution[row] = Position{Row: row, Col: bits.TrailingZeros64(bit)}
+		if !s.place(row+1, cols|bit, (diag|bit)>>1, (anti|bit)<<1, solution, visit) {
+			return false
+		}
+	}
+	return true
+}
+
+// Solutions returns up to limit solutions; a limit of 0 returns all of them.
+func (s *Solver) Solutions(limit int) [][]Position {
+	var solutions [][]Position
+	s.Each(func(solution []Position) bool {
+		solutions = append(solutions, append([]Position(nil), solution...))
+		return limit == 0 || len(solutions) < limit
+	})
+	return solutions
+}
+
+// Count returns the number of solutions, stopping early once limit is
+// reached; a limit of 0 counts all of them.
+func (s *Solver) Count(limit int) int {
+	count := 0
+	s.Each(func([]Position) bool {
+		count++
+		return limit == 0 || count < limit
+	})
+	return count
+}
+
+// CountWithin is Count trying at most nodes partial placements. It reports
+// false if they ran out first, when the count is only a lower bound.
+func (s *Solver) CountWithin(limit int, nodes int) (int, bool) {
+	s.budget, s.exhausted = nodes, false
+	defer func() { s.budget = -1 }()
+
+	count := s.Count(limit)
+	return count, !s.exhausted
+}
+
+// Hint returns an empty cell where a queen can go without making the
+// placement unsolvable, taken from the first solution that completes it.
+// Of that solution's new queens it picks the one closest to nearRow.
+func Hint(queens *Queens, nearRow int) (Position, error) {
+	solver, err := NewSolverFrom(queens)
+	if err != nil {
+		return Position{}, ErrUnsolvable
+	}
+
+	solutions := solver.Solutions(1)
+	if len(solutions) == 0 {
+		return Position{}, ErrUnsolvable
+	}
+
+	hint := Position{Row: -1}
+	for _, pos := range solutions[0] {
+		if queens.HasQueen(pos.Row, pos.Col) {
+			continue
+		}
+		if hint.Row == -1 || abs(pos.Row-nearRow) < abs(hint.Row-nearRow) {
+			hint = pos
+		}
+	}
+
+	if hint.Row == -1 {
+		return Position{}, ErrUnsolvable
+	}
+	return hint, nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
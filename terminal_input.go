@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"time"
+	"unicode"
 
 	"golang.org/x/term"
 )
@@ -12,6 +15,13 @@ type Terminal struct {
 	reset       func()
 	noExit      bool
 	commandMode bool
+	keymap      Keymap
+	decoder     KeyDecoder
+	input       chan inputChunk
+	resized     chan os.Signal
+	// err is why reading stopped, io.EOF included. Once set, every call
+	// to ReadInput returns it, since nothing more will be read.
+	err error
 }
 
 type Code int
@@ -19,18 +29,34 @@ type Code int
 const (
 	CodeExit Code = iota
 	CodeReset
+	CodeUndo
+	CodeRedo
 	CodeLeft
 	CodeRight
 	CodeUp
 	CodeDown
+	CodeHome
+	CodeEnd
+	CodePageUp
+	CodePageDown
 	CodePlace
 	CodeHelp
+	CodeHint
+	CodeVariants
 	CodeSymbolBlack
 	CodeSymbolWhite
 	CodeSymbolAscii
+	CodeClick
+	CodeHover
 	CodeCommand
 	CodeCancelCommand
+	CodeBackspace
+	CodeDelete
+	CodeDeleteWord
+	CodeClearLine
+	CodeComplete
 	CodeChar
+	CodeResize
 	CodeNone
 )
 
@@ -45,22 +71,29 @@ func NewCmd(code Code) Cmd {
 	}
 }
 
-func RawTerminal(noExit bool) Terminal {
+func RawTerminal(noExit bool, keymap Keymap) Terminal {
 	state, err := term.MakeRaw(getTermFd())
 	if err != nil {
 		panic(fmt.Errorf("cannot set terminal to raw mode: %v", err))
 	}
+	input := make(chan inputChunk)
+	go readStdin(input)
+
 	return Terminal{
 		reset: func() {
 			if err := term.Restore(getTermFd(), state); err != nil {
 				panic(fmt.Errorf("cannot restore terminal state: %v", err))
 			}
 		},
-		noExit: noExit,
+		noExit:  noExit,
+		keymap:  keymap,
+		input:   input,
+		resized: notifyResize(),
 	}
 }
 
 func (t *Terminal) Restore() {
+	signal.Stop(t.resized)
 	t.reset()
 }
 
@@ -68,71 +101,152 @@ func (t *Terminal) SetCommandMode(mode bool) {
 	t.commandMode = mode
 }
 
-func (t *Terminal) ReadInput() (Cmd, error) {
-	buf := make([]byte, 3)
-	n, err := os.Stdin.Read(buf)
-	if err == io.EOF {
-		return NewCmd(CodeNone), nil
-	} else if err != nil {
-		return NewCmd(CodeNone), fmt.Errorf("error reading from stdin: %v", err)
+// escTimeout is how long a lone ESC waits for the rest of a sequence
+// before it counts as the Esc key.
+const escTimeout = 50 * time.Millisecond
+
+type inputChunk struct {
+	data []byte
+	err  error
+}
+
+// readStdin sends everything read from stdin to input until reading fails.
+func readStdin(input chan<- inputChunk) {
+	for {
+		buf := make([]byte, 256)
+		n, err := os.Stdin.Read(buf)
+		input <- inputChunk{data: buf[:n], err: err}
+		if err != nil {
+			return
+		}
 	}
-	if n > 0 {
-		if n == 1 {
-			char := buf[0]
-
-			if t.commandMode {
-				if char == 0x1b {
-					return NewCmd(CodeCancelCommand), nil
-				} else if char == '\r' || char == '\n' {
-					return NewCmd(CodePlace), nil
-				} else if isPrintable(char) {
-					cmd := NewCmd(CodeChar)
-					cmd.Data = rune(char)
-					return cmd, nil
-				}
-				return NewCmd(CodeNone), nil
-			}
+}
 
-			if char == 0x1b {
-				if t.noExit {
-					return NewCmd(CodeNone), nil
-				}
-				return NewCmd(CodeExit), nil
-			} else if char == ':' {
-				return NewCmd(CodeCommand), nil
-			} else if char == 'r' || char == 'R' {
-				return NewCmd(CodeReset), nil
-			} else if char == 'h' || char == 'H' {
-				return NewCmd(CodeHelp), nil
-			} else if char == 'b' || char == 'B' {
-				return NewCmd(CodeSymbolBlack), nil
-			} else if char == 'w' || char == 'W' {
-				return NewCmd(CodeSymbolWhite), nil
-			} else if char == 'q' || char == 'Q' {
-				return NewCmd(CodeSymbolAscii), nil
-			} else if char == ' ' || char == '\r' || char == '\n' {
-				return NewCmd(CodePlace), nil
-			}
-		} else if n == 3 && buf[0] == 0x1b && buf[1] == '[' {
-			switch buf[2] {
-			case 'A':
-				return NewCmd(CodeUp), nil
-			case 'B':
-				return NewCmd(CodeDown), nil
-			case 'C':
-				return NewCmd(CodeRight), nil
-			case 'D':
-				return NewCmd(CodeLeft), nil
-			default:
-				return NewCmd(CodeNone), nil
+// ReadInput returns the command for the next key pressed, or CodeResize
+// when the window is resized. Keys that arrive together, as when pasting,
+// are returned one per call. Once stdin is closed or fails, and the keys
+// read before have been returned, it returns the error on every call.
+func (t *Terminal) ReadInput() (Cmd, error) {
+	for {
+		if event, ok := t.decoder.Next(false); ok {
+			return t.command(event), nil
+		}
+		if t.err != nil {
+			if event, ok := t.decoder.Next(true); ok {
+				return t.command(event), nil
 			}
+			return NewCmd(CodeNone), t.err
+		}
+
+		// A nil channel never receives, so the timeout only applies while
+		// a sequence is pending.
+		var timeout <-chan time.Time
+		if t.decoder.Pending() {
+			timeout = time.After(escTimeout)
+		}
+
+		var chunk inputChunk
+		select {
+		case chunk = <-t.input:
+		case <-timeout:
+			event, _ := t.decoder.Next(true)
+			return t.command(event), nil
+		case <-t.resized:
+			return NewCmd(CodeResize), nil
+		}
+
+		t.decoder.Feed(chunk.data)
+		if chunk.err == io.EOF {
+			t.err = io.EOF
+		} else if chunk.err != nil {
+			t.err = fmt.Errorf("error reading from stdin: %w", chunk.err)
+		}
+	}
+}
+
+// command maps a key to what it does on the board, as bound in the keymap,
+// or on the command line in command mode, where the keys are fixed.
+func (t *Terminal) command(event KeyEvent) Cmd {
+	if t.commandMode {
+		return commandLineCommand(event)
+	}
+
+	if event.Key == KeyMouse {
+		return mouseCommand(event.Mouse)
+	}
+
+	code := t.keymap.Lookup(event)
+	if code == CodeExit && t.noExit {
+		return NewCmd(CodeNone)
+	}
+	return NewCmd(code)
+}
+
+// mouseCommand maps a left click to CodeClick and moving the mouse with no
+// button held to CodeHover, both with the Mouse as data.
+func mouseCommand(mouse Mouse) Cmd {
+	code := CodeNone
+	switch {
+	case mouse.Button == MouseLeft && !mouse.Motion && !mouse.Release:
+		code = CodeClick
+	case mouse.Button == MouseNone && mouse.Motion:
+		code = CodeHover
+	}
+
+	cmd := NewCmd(code)
+	if code != CodeNone {
+		cmd.Data = mouse
+	}
+	return cmd
+}
+
+func commandLineCommand(event KeyEvent) Cmd {
+	switch event.Key {
+	case KeyEsc:
+		return NewCmd(CodeCancelCommand)
+	case KeyEnter:
+		return NewCmd(CodePlace)
+	case KeyBackspace:
+		return NewCmd(CodeBackspace)
+	case KeyDelete:
+		return NewCmd(CodeDelete)
+	case KeyTab:
+		if event.Mod == 0 {
+			return NewCmd(CodeComplete)
+		}
+	case KeyUp:
+		return NewCmd(CodeUp)
+	case KeyDown:
+		return NewCmd(CodeDown)
+	case KeyLeft:
+		return NewCmd(CodeLeft)
+	case KeyRight:
+		return NewCmd(CodeRight)
+	case KeyHome:
+		return NewCmd(CodeHome)
+	case KeyEnd:
+		return NewCmd(CodeEnd)
+	case KeyRune:
+		switch {
+		case event.Mod == ModCtrl && event.Rune == 'w':
+			return NewCmd(CodeDeleteWord)
+		case event.Mod == ModCtrl && event.Rune == 'u':
+			return NewCmd(CodeClearLine)
+		case event.Mod == ModCtrl && event.Rune == 'a':
+			return NewCmd(CodeHome)
+		case event.Mod == ModCtrl && event.Rune == 'e':
+			return NewCmd(CodeEnd)
+		case event.Mod == 0 && isPrintable(event.Rune):
+			cmd := NewCmd(CodeChar)
+			cmd.Data = event.Rune
+			return cmd
 		}
 	}
-	return NewCmd(CodeNone), nil
+	return NewCmd(CodeNone)
 }
 
-func isPrintable(char byte) bool {
-	return char >= 32 && char <= 126
+func isPrintable(char rune) bool {
+	return unicode.IsPrint(char)
 }
 
 func getTermFd() int {
@@ -2,72 +2,134 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"unicode/utf8"
 
 	"golang.org/x/term"
 )
 
-func renderScreen(queens Queens, cursorRow, cursorCol int, showHelp bool, noExit bool, hard bool, commandBuffer string, solved [12]int, prizes []Prize) {
-	fmt.Print("\033[H\033[2J")
-
-	termWidth := getTerminalWidth()
-	isSolved := queens.IsSolved()
+// TextRenderer draws the screen as lines of text, laid out by layoutPanels
+// to suit the size of the terminal. The ANSI renderer colours them and
+// draws them on a Screen, which only updates what changed. The plain one
+// marks the cursor, hints and what has been won with characters instead,
+// for output that isn't a terminal.
+type TextRenderer struct {
+	w      io.Writer
+	screen *Screen
+	ansi   bool
+	// size returns the width and height to lay the screen out in. A height
+	// of 0 is unlimited.
+	size func() (int, int)
+
+	panels []panel
+	// boardGutter is the width of the labels left of the board.
+	boardGutter int
+
+	boardX int
+	boardY int
+}
 
-	renderTitle(termWidth, isSolved)
+func NewANSIRenderer(w io.Writer) *TextRenderer {
+	return &TextRenderer{screen: NewScreen(w), ansi: true, size: getTerminalSize}
+}
 
-	prettyString := queens.Pretty(cursorRow, cursorCol, showHelp, hard)
-	lines := strings.Split(prettyString, "\n")
+func NewPlainRenderer(w io.Writer, width int) *TextRenderer {
+	return &TextRenderer{w: w, size: func() (int, int) { return width, 0 }}
+}
 
-	for _, line := range lines {
-		printCentered(line, termWidth)
+func getTerminalSize() (int, int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 80, 0
 	}
+	return width, height
+}
 
-	fmt.Print("\r\n")
-
-	renderStatus(queens, showHelp, termWidth, isSolved, hard)
-
-	fmt.Print("\r\n")
+func (r *TextRenderer) Begin() {
+	r.panels = nil
+}
 
-	renderDiscoveryGrid(termWidth, solved)
+func (r *TextRenderer) add(place placement, hideOrder int, lines ...string) {
+	r.panels = append(r.panels, panel{lines: lines, place: place, hideOrder: hideOrder})
+}
 
-	fmt.Print("\r\n")
+// addBoxed adds a panel drawn in a frame, whose lines are never wrapped.
+func (r *TextRenderer) addBoxed(place placement, hideOrder int, lines ...string) {
+	r.panels = append(r.panels, panel{lines: lines, place: place, hideOrder: hideOrder, boxed: true})
+}
 
-	solvedCount := 0
-	for _, s := range solved {
-		solvedCount += s
+// Resized makes the next screen redraw the whole terminal.
+func (r *TextRenderer) Resized() {
+	if r.screen != nil {
+		r.screen.Invalidate()
 	}
-	renderPrizes(termWidth, prizes, solvedCount)
-
-	renderControls(termWidth, isSolved, noExit, hard)
+}
 
-	if commandBuffer != "" {
-		renderCommandLine(commandBuffer, termWidth)
+// paint colours text with an SGR parameter such as "32" for green, if the
+// renderer uses ANSI escapes.
+func (r *TextRenderer) paint(sgr string, text string) string {
+	if !r.ansi {
+		return text
 	}
+	return "\033[" + sgr + "m" + text + "\033[0m"
 }
 
-func getTerminalWidth() int {
-	width, _, err := term.GetSize(int(os.Stdout.Fd()))
-	if err != nil {
-		return 80
+// End lays out the panels that fit and draws them: the ANSI renderer on its
+// screen, cut to the size of the terminal so that nothing scrolls off it,
+// and the plain one as text.
+func (r *TextRenderer) End() error {
+	termWidth, termHeight := r.size()
+	layout := layoutPanels(r.panels, termWidth, termHeight)
+	r.boardX, r.boardY = layout.boardX+r.boardGutter, layout.boardY
+
+	lines := layout.lines
+	if r.screen != nil {
+		if termHeight > 0 && len(lines) > termHeight {
+			lines = lines[:termHeight]
+		}
+		for i, line := range lines {
+			lines[i] = clipLine(line, termWidth)
+		}
+		return r.screen.Draw(lines)
 	}
-	return width
+	_, err := io.WriteString(r.w, strings.Join(lines, "\n")+"\n")
+	return err
 }
 
-func printCentered(line string, termWidth int) {
-	visibleLen := getVisibleLength(line)
+func (r *TextRenderer) BoardOrigin() (x, y int) {
+	return r.boardX, r.boardY
+}
 
-	if visibleLen >= termWidth {
-		fmt.Print(line)
-		fmt.Print("\r\n")
-		return
+// clipLine cuts a line to width visible columns, keeping its escapes and
+// resetting any colour left on where it is cut.
+func clipLine(line string, width int) string {
+	visible := 0
+	inEscape := false
+
+	for i, char := range line {
+		if char == '\033' {
+			inEscape = true
+		} else if inEscape {
+			if char == 'm' {
+				inEscape = false
+			}
+		} else {
+			if visible == width {
+				return line[:i] + "\033[0m"
+			}
+			visible++
+		}
 	}
 
-	leftPadding := (termWidth - visibleLen) / 2
+	return line
+}
 
-	fmt.Print(strings.Repeat(" ", leftPadding))
-	fmt.Print(line)
-	fmt.Print("\r\n")
+// centerPadding returns the number of columns left of a line centred on
+// the terminal.
+func centerPadding(line string, termWidth int) int {
+	return max(termWidth-getVisibleLength(line), 0) / 2
 }
 
 func getVisibleLength(s string) int {
@@ -89,121 +151,290 @@ func getVisibleLength(s string) int {
 	return length
 }
 
-func renderTitle(termWidth int, isSolved bool) {
-	fmt.Print("\033[33m")
-	printCentered("╔════════════════════════════╗", termWidth)
-	printCentered("║   8-Queens Puzzle (v1.0)   ║", termWidth)
-	printCentered("╚════════════════════════════╝", termWidth)
-	fmt.Print("\033[0m")
-	fmt.Print("\r\n")
+// Title names the puzzle in a banner, which turns green and says so once
+// the board is solved.
+func (r *TextRenderer) Title(size int, solved bool) {
+	title := fmt.Sprintf("%d-Queens Puzzle (v1.0)", size)
+	colour := "33"
+	if solved {
+		title = fmt.Sprintf("%d-Queens Puzzle: Solved!", size)
+		colour = "32"
+	}
+	leftPadding := (28 - len(title)) / 2
+	rightPadding := 28 - len(title) - leftPadding
+
+	r.add(placeTop, hideTitle,
+		r.paint(colour, "╔════════════════════════════╗"),
+		r.paint(colour, "║"+strings.Repeat(" ", leftPadding)+title+strings.Repeat(" ", rightPadding)+"║"),
+		r.paint(colour, "╚════════════════════════════╝"),
+	)
 }
 
-func formatPrizeText(prize Prize) string {
-	return fmt.Sprintf("[+ %3d¢] %s", prize.Cents, prize.Label)
+func (r *TextRenderer) Board(board BoardView) {
+	r.boardGutter = labelGutter(board)
+	r.add(placeBoard, showAlways, boardLines(board, r.ansi)...)
 }
 
-func renderPrizes(termWidth int, prizes []Prize, solvedCount int) {
-	fmt.Print("\033[36m")
-	printCentered("Prizes:", termWidth)
-	fmt.Print("\033[0m")
+// boardLines draws a board as a grid of cells 3 columns wide and 1 row
+// high, with a border line around each. The ranks are labelled on the left
+// and the files below, each line being as wide as the rest.
+func boardLines(board BoardView, ansi bool) []string {
+	gutter := labelGutter(board)
+	margin := strings.Repeat(" ", gutter)
+	border := func(left, middle, right string) string {
+		return margin + left + strings.Repeat("───"+middle, board.Size-1) + "───" + right
+	}
 
-	for _, prize := range prizes {
-		prizeText := formatPrizeText(prize)
-		visibleLen := len(prizeText)
+	lines := []string{border("┌", "┬", "┐")}
+	for row, cells := range board.Cells {
+		line := margin
+		if board.Ranks != nil {
+			line = fmt.Sprintf("%*s ", gutter-1, board.Ranks[row])
+		}
+		line += "│"
+		for _, cell := range cells {
+			line += cellText(cell, board, ansi) + "│"
+		}
+		lines = append(lines, line)
 
-		leftPadding := (termWidth - visibleLen) / 2
-		if leftPadding < 0 {
-			leftPadding = 0
+		if row < board.Size-1 {
+			lines = append(lines, border("├", "┼", "┤"))
 		}
+	}
+	lines = append(lines, border("└", "┴", "┘"))
 
-		fmt.Print(strings.Repeat(" ", leftPadding))
-		if solvedCount >= prize.Solutions {
-			fmt.Print("\033[32m")
+	if board.Files != nil {
+		line := margin + " "
+		for _, file := range board.Files {
+			line += fmt.Sprintf("%3s ", file+" ")
 		}
-		fmt.Print(prizeText)
-		if solvedCount >= prize.Solutions {
-			fmt.Print("\033[0m")
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// labelGutter is the number of columns left of the board for the labels
+// of its ranks.
+func labelGutter(board BoardView) int {
+	gutter := 0
+	for _, rank := range board.Ranks {
+		gutter = max(gutter, utf8.RuneCountInString(rank)+1)
+	}
+	return gutter
+}
+
+func cellText(cell Cell, board BoardView, ansi bool) string {
+	queen := " " + board.Symbol + " "
+	if !ansi {
+		switch cell {
+		case CellAttacked:
+			return " · "
+		case CellHint:
+			return " ? "
+		case CellCursor:
+			return "[ ]"
+		case CellQueen, CellQueenSafe:
+			return queen
+		case CellQueenCursor:
+			return "[" + board.Symbol + "]"
+		case CellQueenAttacked:
+			return "!" + board.Symbol + "!"
+		default:
+			return "   "
 		}
-		fmt.Print("\r\n")
 	}
 
-	fmt.Print("\r\n")
+	switch cell {
+	case CellAttacked:
+		return "\033[41m   \033[0m"
+	case CellHint:
+		return "\033[1;30;42m ? \033[0m"
+	case CellCursor:
+		return "\033[1;7m   \033[0m"
+	case CellQueen:
+		return "\033[1m" + queen + "\033[0m"
+	case CellQueenCursor:
+		if board.Hard {
+			return "\033[1;33;7m" + queen + "\033[0m"
+		}
+		return "\033[1;7m" + queen + "\033[0m"
+	case CellQueenSafe:
+		return "\033[1;32m" + queen + "\033[0m"
+	case CellQueenAttacked:
+		return "\033[1;31;7m" + queen + "\033[0m"
+	default:
+		return "   "
+	}
 }
 
-func renderDiscoveryGrid(termWidth int, solved [12]int) {
-	fmt.Print("\033[36m")
-	printCentered("Fundamental Solutions:", termWidth)
-	fmt.Print("\033[0m")
+func (r *TextRenderer) Status(status StatusView) {
+	line := r.paint("32", fmt.Sprintf("Queens: %d/%d  Cursor: %s", status.Count, status.Size, status.Cursor))
+	if status.Solved {
+		line += "  " + r.paint("1;32", "✓ Solved!")
+	}
 
-	row1 := ""
-	for i := 0; i < 6; i++ {
-		cellNum := fmt.Sprintf("%02d", i+1)
-		if solved[i] == 1 {
-			row1 += fmt.Sprintf("\033[32m[%s]\033[0m ", cellNum)
-		} else {
-			row1 += fmt.Sprintf("[%s] ", cellNum)
+	details := fmt.Sprintf("  Symbol: %s", status.Symbol)
+	if !status.Hard {
+		helpStatus := "OFF"
+		if status.Help {
+			helpStatus = "ON"
 		}
+		details += fmt.Sprintf("  Help: %s", helpStatus)
 	}
-	printCentered(strings.TrimSpace(row1), termWidth)
+	lines := []string{line + r.paint("32", details)}
 
-	row2 := ""
-	for i := 6; i < 12; i++ {
-		cellNum := fmt.Sprintf("%02d", i+1)
-		if solved[i] == 1 {
-			row2 += fmt.Sprintf("\033[32m[%s]\033[0m ", cellNum)
-		} else {
-			row2 += fmt.Sprintf("[%s] ", cellNum)
+	if !status.Hard && !status.Solved && status.Reachable != -1 {
+		reachable := formatReachable(status.Reachable)
+		if status.Reachable == 0 {
+			reachable = r.paint("31", reachable)
 		}
+		lines = append(lines, reachable)
 	}
-	printCentered(strings.TrimSpace(row2), termWidth)
 
-	fmt.Print("\r\n")
+	if status.Untracked {
+		lines = append(lines, fmt.Sprintf("Fundamentals aren't tracked above %dx%d", maxGeneratedSize, maxGeneratedSize))
+	}
+
+	if status.Message != "" {
+		lines = append(lines, r.paint("33", status.Message))
+	}
+	r.add(placeSide, showAlways, lines...)
 }
 
-func renderStatus(queens Queens, showHelp bool, termWidth int, isSolved bool, hard bool) {
-	fmt.Print("\033[32m")
+const discoveryGridColumns = 6
 
-	status := fmt.Sprintf("Queens: %d/8", queens.Count())
-	if isSolved {
-		status += "  \033[1;32m✓ Solved!\033[0m\033[32m"
+// DiscoveryGrid shows a cell for each fundamental solution, green or, in
+// plain text, bracketed once found.
+func (r *TextRenderer) DiscoveryGrid(grid DiscoveryView) {
+	lines := []string{r.paint("36", "Fundamental Solutions:")}
+
+	if len(grid.Solved) == 0 {
+		r.add(placeSide, hideGrid, append(lines, fmt.Sprintf("Not tracked above %dx%d", maxGeneratedSize, maxGeneratedSize))...)
+		return
 	}
 
-	status += fmt.Sprintf("  Symbol: %s", queens.GetSymbol())
+	if grid.Summary {
+		r.add(placeSide, hideGrid, append(lines,
+			fmt.Sprintf("Found %d of %d", countSolved(grid.Solved), len(grid.Solved)),
+			FormatOrbitSum(grid.Orbits)+" solutions",
+		)...)
+		return
+	}
 
-	if !hard {
-		helpStatus := "OFF"
-		if showHelp {
-			helpStatus = "ON"
+	for start := 0; start < len(grid.Solved); start += discoveryGridColumns {
+		end := min(start+discoveryGridColumns, len(grid.Solved))
+
+		row := ""
+		for i := start; i < end; i++ {
+			cellNum := fmt.Sprintf("%02d", i+1)
+			// Solutions with symmetries of their own are starred.
+			mark := " "
+			if grid.Orbits[i] < 8 {
+				mark = "*"
+			}
+			switch {
+			case grid.Solved[i] == 0 && !r.ansi:
+				row += " " + cellNum + " " + mark
+			case grid.Solved[i] == 0:
+				row += "[" + cellNum + "]" + mark
+			default:
+				row += r.paint("32", "["+cellNum+"]") + mark
+			}
+		}
+		lines = append(lines, strings.TrimRight(row, " "))
+	}
+	r.add(placeSide, hideGrid, append(lines, FormatOrbitSum(grid.Orbits)+" solutions")...)
+}
+
+const variantsGridColumns = 3
+
+// VariantsGrid shows, for each fundamental solution, which of its
+// rotations and reflections have been found.
+func (r *TextRenderer) VariantsGrid(grid VariantsView) {
+	heading := fmt.Sprintf("Distinct Solutions: %d", grid.Found)
+	if grid.Total > 0 {
+		heading = fmt.Sprintf("Distinct Solutions: %d/%d", grid.Found, grid.Total)
+	}
+	lines := []string{r.paint("36", heading)}
+
+	var cells []string
+	for i, variants := range grid.Variants {
+		cell := fmt.Sprintf("[%02d] ", i+1)
+		for _, found := range variants {
+			if found {
+				cell += r.paint("32", "●")
+			} else {
+				cell += "○"
+			}
 		}
-		status += fmt.Sprintf("  Help: %s", helpStatus)
+		cell += strings.Repeat(" ", 8-len(variants))
+		cells = append(cells, cell)
 	}
 
-	printCentered(status, termWidth)
-	fmt.Print("\033[0m")
-	fmt.Print("\r\n")
+	for start := 0; start < len(cells); start += variantsGridColumns {
+		end := min(start+variantsGridColumns, len(cells))
+		lines = append(lines, strings.TrimSpace(strings.Join(cells[start:end], "  ")))
+	}
+	r.add(placeSide, hideGrid, lines...)
 }
 
-func renderControls(termWidth int, isSolved bool, noExit bool, hard bool) {
-	fmt.Print("\033[36m")
-	printCentered("┌────────────────────────────┐", termWidth)
-	printCentered("│ Controls:                  │", termWidth)
-	if !noExit {
-		printCentered("│ [Esc]       Exit           │", termWidth)
+func (r *TextRenderer) Prizes(prizes PrizesView) {
+	lines := []string{r.paint("36", "Prizes:")}
+	for i, prize := range prizes.Prizes {
+		prizeText := formatPrizeText(prize)
+		switch {
+		case prizes.Won[i] && r.ansi:
+			prizeText = r.paint("32", prizeText)
+		case prizes.Won[i]:
+			prizeText += " ✓"
+		}
+		lines = append(lines, prizeText)
 	}
-	printCentered("│ [r]         Reset board    │", termWidth)
-	if !hard {
-		printCentered("│ [h]         Toggle help    │", termWidth)
+	r.add(placeSide, hidePrizes, lines...)
+}
+
+func (r *TextRenderer) Controls(controls []ControlView) {
+	const labelWidth = 15
+	keyWidth := 12
+	for _, control := range controls {
+		keyWidth = max(keyWidth, utf8.RuneCountInString(control.Keys)+4)
+	}
+
+	width := 1 + keyWidth + labelWidth
+	pad := func(text string, width int) string {
+		return text + strings.Repeat(" ", max(width-utf8.RuneCountInString(text), 0))
 	}
-	printCentered("│ [Space]     Toggle queen   │", termWidth)
-	printCentered("│ [b/w/q]     Change symbol  │", termWidth)
-	printCentered("│ [Arrows]    Move cursor    │", termWidth)
-	printCentered("└────────────────────────────┘", termWidth)
-	fmt.Print("\033[0m")
+
+	lines := []string{
+		r.paint("36", "┌"+strings.Repeat("─", width)+"┐"),
+		r.paint("36", "│"+pad(" Controls:", width)+"│"),
+	}
+	for _, control := range controls {
+		lines = append(lines, r.paint("36", "│ "+pad("["+control.Keys+"]", keyWidth)+pad(control.Label, labelWidth)+"│"))
+	}
+	r.addBoxed(placeSide, hideControls, append(lines, r.paint("36", "└"+strings.Repeat("─", width)+"┘"))...)
 }
 
-func renderCommandLine(commandBuffer string, termWidth int) {
-	fmt.Print("\r\n")
-	fmt.Print("\033[33m")
-	printCentered(commandBuffer, termWidth)
-	fmt.Print("\033[0m")
+// CommandLine shows the command being typed with its cursor in reverse
+// video, or as '|' in plain text, or the error from the last one run.
+func (r *TextRenderer) CommandLine(line CommandLineView) {
+	if line.Error != "" {
+		r.add(placeBottom, showAlways, r.paint("31", line.Error))
+		return
+	}
+
+	text := []rune(line.Text)
+	before := string(text[:line.Cursor])
+	if !r.ansi {
+		r.add(placeBottom, showAlways, ":"+before+"|"+string(text[line.Cursor:]))
+		return
+	}
+
+	under := " "
+	after := ""
+	if line.Cursor < len(text) {
+		under = string(text[line.Cursor])
+		after = string(text[line.Cursor+1:])
+	}
+	r.add(placeBottom, showAlways, r.paint("33", ":"+before+"\033[7m"+under+"\033[27m"+after))
 }
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyResize returns nil where there is no SIGWINCH: a nil channel never
+// receives, and the screen adapts to a new size on the next redraw instead.
+func notifyResize() chan os.Signal {
+	return nil
+}